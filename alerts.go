@@ -0,0 +1,175 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Alert rule types
+const (
+	// AlertBelow fires when an offer's price drops below Threshold. Provider
+	// limits the rule to one platform; empty means any provider.
+	AlertBelow = "below"
+	// AlertCheaperThan fires when Provider becomes cheaper than Than
+	AlertCheaperThan = "cheaperThan"
+)
+
+// AlertRule is a condition attached to a real-time subscription
+type AlertRule struct {
+	ID        string  `json:"id,omitempty"`
+	Type      string  `json:"type"`
+	Provider  string  `json:"provider,omitempty"`
+	Threshold float64 `json:"threshold,omitempty"`
+	Than      string  `json:"than,omitempty"`
+}
+
+// Alert describes a rule that fired
+type Alert struct {
+	SubscriptionID string  `json:"subscriptionId,omitempty"`
+	RuleID         string  `json:"ruleId"`
+	Category       string  `json:"category"`
+	Route          string  `json:"route,omitempty"`
+	Location       string  `json:"location,omitempty"`
+	Provider       string  `json:"provider"`
+	Price          float64 `json:"price"`
+	Message        string  `json:"message"`
+}
+
+// AlertMessage is pushed to a WebSocket client when a rule fires
+type AlertMessage struct {
+	Version   int    `json:"v"`
+	Type      string `json:"type"`
+	Alert     Alert  `json:"alert"`
+	Timestamp int64  `json:"timestamp"`
+}
+
+// Check that a rule is complete
+func (rule AlertRule) validate() error {
+	switch rule.Type {
+	case AlertBelow:
+		if rule.Threshold <= 0 {
+			return fmt.Errorf("alert %q: threshold must be positive", rule.ID)
+		}
+	case AlertCheaperThan:
+		if rule.Provider == "" || rule.Than == "" {
+			return fmt.Errorf("alert %q: provider and than are required", rule.ID)
+		}
+		if strings.EqualFold(rule.Provider, rule.Than) {
+			return fmt.Errorf("alert %q: provider and than must differ", rule.ID)
+		}
+	default:
+		return fmt.Errorf("alert %q: unknown type %q", rule.ID, rule.Type)
+	}
+	return nil
+}
+
+// alertState tracks which rules of a subscription are currently firing, so
+// that a rule only alerts when its condition becomes true and re-arms once
+// the condition clears
+type alertState struct {
+	rules  []AlertRule
+	firing map[string]bool
+}
+
+// Build the alert state for a subscription's rules. Rules without an ID get
+// one from their position; invalid rules are returned as errors and skipped.
+func newAlertState(rules []AlertRule) (*alertState, []error) {
+	state := &alertState{firing: make(map[string]bool)}
+
+	var errs []error
+	for i, rule := range rules {
+		if rule.ID == "" {
+			rule.ID = fmt.Sprintf("alert-%d", i+1)
+		}
+		if err := rule.validate(); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		state.rules = append(state.rules, rule)
+	}
+	return state, errs
+}
+
+// Evaluate the rules against the latest offers and return the alerts for
+// rules that started firing
+func (s *alertState) evaluate(response RealTimeResponse) []Alert {
+	var alerts []Alert
+
+	for _, rule := range s.rules {
+		alert, matched := matchAlertRule(rule, response)
+		if !matched {
+			s.firing[rule.ID] = false
+			continue
+		}
+		if s.firing[rule.ID] {
+			continue
+		}
+		s.firing[rule.ID] = true
+		alerts = append(alerts, alert)
+	}
+	return alerts
+}
+
+func matchAlertRule(rule AlertRule, response RealTimeResponse) (Alert, bool) {
+	alert := Alert{
+		SubscriptionID: response.ID,
+		RuleID:         rule.ID,
+		Category:       response.Category,
+		Route:          response.Route,
+		Location:       response.Location,
+	}
+
+	where := response.Route
+	if where == "" {
+		where = response.Location
+	}
+
+	switch rule.Type {
+	case AlertBelow:
+		// Report the cheapest matching offer
+		var cheapest *ServiceOffer
+		for i, offer := range response.Offers {
+			if rule.Provider != "" && !strings.EqualFold(offer.ServiceName, rule.Provider) {
+				continue
+			}
+			if offer.Price < rule.Threshold && (cheapest == nil || offer.Price < cheapest.Price) {
+				cheapest = &response.Offers[i]
+			}
+		}
+		if cheapest == nil {
+			return alert, false
+		}
+		alert.Provider = cheapest.ServiceName
+		alert.Price = cheapest.Price
+		alert.Message = fmt.Sprintf("%s price for %s dropped to ₹%.2f, below ₹%.2f",
+			cheapest.ServiceName, where, cheapest.Price, rule.Threshold)
+		return alert, true
+
+	case AlertCheaperThan:
+		provider, ok := findOffer(response.Offers, rule.Provider)
+		if !ok {
+			return alert, false
+		}
+		other, ok := findOffer(response.Offers, rule.Than)
+		if !ok || provider.Price >= other.Price {
+			return alert, false
+		}
+		alert.Provider = provider.ServiceName
+		alert.Price = provider.Price
+		alert.Message = fmt.Sprintf("%s (₹%.2f) is now cheaper than %s (₹%.2f) for %s",
+			provider.ServiceName, provider.Price, other.ServiceName, other.Price, where)
+		return alert, true
+	}
+
+	return alert, false
+}
+
+// Find the offer of a provider by name
+func findOffer(offers []ServiceOffer, provider string) (ServiceOffer, bool) {
+	for _, offer := range offers {
+		if strings.EqualFold(offer.ServiceName, provider) {
+			return offer, true
+		}
+	}
+	return ServiceOffer{}, false
+}
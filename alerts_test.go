@@ -0,0 +1,88 @@
+package main
+
+import "testing"
+
+func TestAlertRuleValidate(t *testing.T) {
+	tests := []struct {
+		rule  AlertRule
+		valid bool
+	}{
+		{AlertRule{Type: AlertBelow, Threshold: 1500}, true},
+		{AlertRule{Type: AlertBelow}, false},
+		{AlertRule{Type: AlertCheaperThan, Provider: "Swiggy", Than: "Zomato"}, true},
+		{AlertRule{Type: AlertCheaperThan, Provider: "Swiggy"}, false},
+		{AlertRule{Type: AlertCheaperThan, Provider: "Swiggy", Than: "swiggy"}, false},
+		{AlertRule{Type: "above", Threshold: 10}, false},
+	}
+	for _, tt := range tests {
+		if err := tt.rule.validate(); (err == nil) != tt.valid {
+			t.Errorf("validate(%+v) = %v, want valid %v", tt.rule, err, tt.valid)
+		}
+	}
+}
+
+func TestNewAlertStateSkipsInvalidRules(t *testing.T) {
+	state, errs := newAlertState([]AlertRule{
+		{Type: AlertBelow, Threshold: 100},
+		{Type: AlertBelow},
+	})
+	if len(state.rules) != 1 || state.rules[0].ID != "alert-1" {
+		t.Errorf("rules = %+v", state.rules)
+	}
+	if len(errs) != 1 {
+		t.Errorf("errors = %v, want one", errs)
+	}
+}
+
+func TestAlertStateFiresOnceUntilCleared(t *testing.T) {
+	state, _ := newAlertState([]AlertRule{
+		{ID: "cheap", Type: AlertBelow, Threshold: 1500},
+		{ID: "swiggy", Type: AlertCheaperThan, Provider: "Swiggy", Than: "Zomato"},
+	})
+	response := func(uber, ola float64) RealTimeResponse {
+		return RealTimeResponse{Category: CategoryTaxi, Route: "punjab → delhi", Offers: []ServiceOffer{
+			{ServiceName: "Uber", Price: uber},
+			{ServiceName: "Ola", Price: ola},
+		}}
+	}
+
+	steps := []struct {
+		uber, ola float64
+		want      []string // providers alerted
+	}{
+		{1600, 1700, nil},
+		{1400, 1450, []string{"Uber"}},
+		{1300, 1450, nil}, // still firing
+		{1600, 1700, nil}, // clears
+		{1600, 1200, []string{"Ola"}},
+	}
+	for i, step := range steps {
+		alerts := state.evaluate(response(step.uber, step.ola))
+		var got []string
+		for _, alert := range alerts {
+			if alert.RuleID != "cheap" {
+				t.Errorf("step %d: rule %s fired without its providers", i, alert.RuleID)
+			}
+			got = append(got, alert.Provider)
+		}
+		if len(got) != len(step.want) || (len(got) > 0 && got[0] != step.want[0]) {
+			t.Errorf("step %d: alerts for %v, want %v", i, got, step.want)
+		}
+	}
+}
+
+func TestCheaperThanAlert(t *testing.T) {
+	state, _ := newAlertState([]AlertRule{{ID: "swiggy", Type: AlertCheaperThan, Provider: "swiggy", Than: "zomato"}})
+	response := RealTimeResponse{Category: CategoryRestaurant, Location: "Dominos", Offers: []ServiceOffer{
+		{ServiceName: "Zomato", Price: 300},
+		{ServiceName: "Swiggy", Price: 280},
+	}}
+
+	alerts := state.evaluate(response)
+	if len(alerts) != 1 || alerts[0].Provider != "Swiggy" || alerts[0].Price != 280 {
+		t.Fatalf("alerts = %+v", alerts)
+	}
+	if alerts[0].Message != "Swiggy (₹280.00) is now cheaper than Zomato (₹300.00) for Dominos" {
+		t.Errorf("message = %q", alerts[0].Message)
+	}
+}
@@ -0,0 +1,359 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// Most lines and units per line accepted in one basket
+const (
+	maxBasketLines    = 50
+	maxBasketQuantity = 99
+)
+
+// BasketItem is one line of a basket request
+type BasketItem struct {
+	Item     string `json:"item"`
+	Quantity int    `json:"quantity"`
+}
+
+// BasketRequest is the body of POST /api/compare/quickcommerce/basket
+type BasketRequest struct {
+	Country string       `json:"country"`
+	State   string       `json:"state"`
+	City    string       `json:"city"`
+	Address string       `json:"address"`
+	Items   []BasketItem `json:"items"`
+}
+
+// BasketLine is a priced basket line
+type BasketLine struct {
+	Item      string  `json:"item"`
+	Quantity  int     `json:"quantity"`
+	UnitPrice float64 `json:"unitPrice"`
+	Total     float64 `json:"total"`
+}
+
+// ProviderBasket is the part of a basket ordered from one provider
+type ProviderBasket struct {
+	Provider string       `json:"provider"`
+	Lines    []BasketLine `json:"lines"`
+	// Items the provider could not quote
+	Missing     []string `json:"missing,omitempty"`
+	Subtotal    float64  `json:"subtotal"`
+	DeliveryFee float64  `json:"deliveryFee"`
+	PlatformFee float64  `json:"platformFee"`
+	// GST on the delivery and platform fees
+	FeeGST       float64 `json:"feeGst"`
+	Total        float64 `json:"total"`
+	DeliveryTime int     `json:"deliveryTime,omitempty"`
+}
+
+// BasketSplit is the cheapest way found to order the whole basket, possibly
+// from several providers
+type BasketSplit struct {
+	Orders []ProviderBasket `json:"orders"`
+	Total  float64          `json:"total"`
+	// Saving over the cheapest single provider that has every item
+	Savings float64 `json:"savings"`
+}
+
+// BasketResponse compares a basket across providers
+type BasketResponse struct {
+	Location  string           `json:"location"`
+	Providers []ProviderBasket `json:"providers"`
+	Cheapest  *BasketSplit     `json:"cheapest,omitempty"`
+	// Items no provider could quote
+	Unavailable []string `json:"unavailable,omitempty"`
+}
+
+// basketQuote is what one provider charges for one basket item
+type basketQuote struct {
+	price        float64
+	deliveryTime int
+}
+
+// Round a sum of prices to whole paise
+func roundTotal(amount float64) float64 {
+	return math.Round(amount*100) / 100
+}
+
+// Check the fields of a basket request, reporting every problem by field.
+// Places and items are checked against the catalog by checkBasketCatalog.
+func validateBasket(request BasketRequest) *ProtocolError {
+	fields := make(map[string]string)
+
+	for name, value := range map[string]string{
+		"country": request.Country,
+		"state":   request.State,
+		"city":    request.City,
+		"address": request.Address,
+	} {
+		if strings.TrimSpace(value) == "" {
+			fields[name] = "is required"
+		}
+	}
+
+	switch {
+	case len(request.Items) == 0:
+		fields["items"] = "must list at least one item"
+	case len(request.Items) > maxBasketLines:
+		fields["items"] = fmt.Sprintf("must list at most %d items", maxBasketLines)
+	}
+	seen := make(map[string]bool)
+	for i, line := range request.Items {
+		name := fmt.Sprintf("items[%d]", i)
+		switch {
+		case strings.TrimSpace(line.Item) == "":
+			fields[name] = "item is required"
+		case seen[strings.ToLower(line.Item)]:
+			fields[name] = fmt.Sprintf("%q is listed more than once", line.Item)
+		case line.Quantity < 1 || line.Quantity > maxBasketQuantity:
+			fields[name] = fmt.Sprintf("quantity must be between 1 and %d", maxBasketQuantity)
+		}
+		seen[strings.ToLower(line.Item)] = true
+	}
+
+	if len(fields) == 0 {
+		return nil
+	}
+	return fieldErrors("basket request is invalid", fields)
+}
+
+// Compare a grocery basket across the quick commerce providers
+func compareBasket(w http.ResponseWriter, r *http.Request) {
+	var request BasketRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		writeError(w, r, &ProtocolError{
+			Code:    ErrCodeInvalidMessage,
+			Message: fmt.Sprintf("request body is not a valid basket: %v", err),
+		})
+		return
+	}
+	if perr := validateBasket(request); perr != nil {
+		writeError(w, r, perr)
+		return
+	}
+	if perr := checkBasketCatalog(request); perr != nil {
+		writeError(w, r, perr)
+		return
+	}
+
+	// Report items under their catalog names
+	catalog, _ := locationOptions["groceryItems"].(map[string][]string)
+	for i, line := range request.Items {
+		for _, name := range catalog["items"] {
+			if strings.EqualFold(name, line.Item) {
+				request.Items[i].Item = name
+			}
+		}
+	}
+
+	// Quote every item through the single-item path, so that basket prices
+	// match /api/compare/quickcommerce and follow the same updates
+	quotes := make(map[string]map[string]basketQuote) // provider -> item -> quote
+	var unavailable []string
+	for _, line := range request.Items {
+		offers, err := getOrQuoteOffers(r.Context(), RealTimeRequest{
+			Category:    CategoryQuickCommerce,
+			Country:     strings.ToLower(request.Country),
+			State:       strings.ToLower(request.State),
+			City:        strings.ToLower(request.City),
+			Address:     strings.ToLower(request.Address),
+			GroceryItem: strings.ToLower(line.Item),
+		})
+		if err != nil || len(offers) == 0 {
+			unavailable = append(unavailable, line.Item)
+			continue
+		}
+		for _, offer := range offers {
+			if quotes[offer.ServiceName] == nil {
+				quotes[offer.ServiceName] = make(map[string]basketQuote)
+			}
+			// A single-item quote includes the per-order fees and their
+			// GST, which the basket charges once per order instead
+			item := offer.Breakdown.Item
+			price := roundTotal(item + roundTotal(item*feeScheduleFor(offer.ServiceName).GSTRate))
+			quotes[offer.ServiceName][line.Item] = basketQuote{price: price, deliveryTime: offer.DeliveryTime}
+		}
+	}
+	if len(quotes) == 0 {
+		writeError(w, r, &ProtocolError{
+			Code:    ErrCodeQuoteFailed,
+			Message: "no provider could quote the basket",
+		})
+		return
+	}
+
+	names := sortedKeys(quotes)
+	response := BasketResponse{
+		Location:    fmt.Sprintf("%s, %s, %s", request.Address, request.City, request.State),
+		Unavailable: unavailable,
+	}
+
+	// Full basket at each provider
+	for _, name := range names {
+		var lines []BasketItem
+		var missing []string
+		for _, line := range request.Items {
+			if _, ok := quotes[name][line.Item]; ok {
+				lines = append(lines, line)
+			} else if !containsFold(unavailable, line.Item) {
+				missing = append(missing, line.Item)
+			}
+		}
+		order := priceBasket(name, lines, quotes[name])
+		order.Missing = missing
+		response.Providers = append(response.Providers, order)
+	}
+	sort.SliceStable(response.Providers, func(i, j int) bool {
+		a, b := response.Providers[i], response.Providers[j]
+		if len(a.Missing) != len(b.Missing) {
+			return len(a.Missing) < len(b.Missing)
+		}
+		return a.Total < b.Total
+	})
+
+	var available []BasketItem
+	for _, line := range request.Items {
+		if !containsFold(unavailable, line.Item) {
+			available = append(available, line)
+		}
+	}
+	response.Cheapest = cheapestSplit(available, names, quotes, response.Providers)
+
+	writeJSON(w, r, http.StatusOK, response)
+}
+
+// Price lines at one provider, adding its delivery and platform fees and
+// their GST
+func priceBasket(provider string, items []BasketItem, quotes map[string]basketQuote) ProviderBasket {
+	order := ProviderBasket{Provider: provider, Lines: []BasketLine{}}
+	for _, line := range items {
+		quote := quotes[line.Item]
+		total := roundTotal(quote.price * float64(line.Quantity))
+		order.Lines = append(order.Lines, BasketLine{
+			Item:      line.Item,
+			Quantity:  line.Quantity,
+			UnitPrice: quote.price,
+			Total:     total,
+		})
+		order.Subtotal += total
+		if quote.deliveryTime > order.DeliveryTime {
+			order.DeliveryTime = quote.deliveryTime
+		}
+	}
+
+	order.Subtotal = roundTotal(order.Subtotal)
+	order.DeliveryFee = deliveryFeeFor(provider).For(order.Subtotal)
+	schedule := feeScheduleFor(provider)
+	order.PlatformFee = schedule.PlatformFee
+	order.FeeGST = roundTotal((order.DeliveryFee + order.PlatformFee) * schedule.GSTRate)
+	order.Total = roundTotal(order.Subtotal + order.DeliveryFee + order.PlatformFee + order.FeeGST)
+	return order
+}
+
+// Find the cheapest way to order every item. For each set of providers,
+// every item goes to the provider in the set that sells it cheapest; single
+// items are then moved between orders while that lowers the total, which
+// lets an order reach a free delivery threshold.
+func cheapestSplit(items []BasketItem, providers []string, quotes map[string]map[string]basketQuote, singles []ProviderBasket) *BasketSplit {
+	if len(items) == 0 {
+		return nil
+	}
+
+	var best []ProviderBasket
+	bestTotal := math.Inf(1)
+
+	// Each bit of set selects a provider
+	for set := 1; set < 1<<len(providers); set++ {
+		assignment := make(map[string]string, len(items)) // item -> provider
+		feasible := true
+		for _, line := range items {
+			cheapest := ""
+			for i, name := range providers {
+				quote, ok := quotes[name][line.Item]
+				if set&(1<<i) == 0 || !ok {
+					continue
+				}
+				if cheapest == "" || quote.price < quotes[cheapest][line.Item].price {
+					cheapest = name
+				}
+			}
+			if cheapest == "" {
+				feasible = false
+				break
+			}
+			assignment[line.Item] = cheapest
+		}
+		if !feasible {
+			continue
+		}
+
+		orders, total := improveSplit(items, assignment, quotes)
+		if total < bestTotal-0.001 {
+			best, bestTotal = orders, total
+		}
+	}
+	if best == nil {
+		return nil
+	}
+
+	split := &BasketSplit{Orders: best, Total: roundTotal(bestTotal)}
+	for _, single := range singles {
+		if len(single.Missing) == 0 {
+			split.Savings = roundTotal(single.Total - split.Total)
+			break
+		}
+	}
+	return split
+}
+
+// Move single items between providers while that lowers the total, and
+// return the resulting orders
+func improveSplit(items []BasketItem, assignment map[string]string, quotes map[string]map[string]basketQuote) ([]ProviderBasket, float64) {
+	orders, total := buildSplit(items, assignment, quotes)
+
+	for improved := true; improved; {
+		improved = false
+		for _, line := range items {
+			current := assignment[line.Item]
+			for _, name := range sortedKeys(quotes) {
+				if _, ok := quotes[name][line.Item]; !ok || name == current {
+					continue
+				}
+				assignment[line.Item] = name
+				candidate, candidateTotal := buildSplit(items, assignment, quotes)
+				if candidateTotal < total-0.001 {
+					orders, total, current = candidate, candidateTotal, name
+					improved = true
+				} else {
+					assignment[line.Item] = current
+				}
+			}
+		}
+	}
+	return orders, total
+}
+
+// Price the orders an assignment of items to providers produces
+func buildSplit(items []BasketItem, assignment map[string]string, quotes map[string]map[string]basketQuote) ([]ProviderBasket, float64) {
+	byProvider := make(map[string][]BasketItem)
+	for _, line := range items {
+		provider := assignment[line.Item]
+		byProvider[provider] = append(byProvider[provider], line)
+	}
+
+	var orders []ProviderBasket
+	total := 0.0
+	for _, name := range sortedKeys(byProvider) {
+		order := priceBasket(name, byProvider[name], quotes[name])
+		orders = append(orders, order)
+		total += order.Total
+	}
+	return orders, total
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// Quotes of two providers for a three-line basket. Zepto's milk alone reaches
+// its free delivery threshold, and Blinkit's bread and rice reach its own.
+func testBasketQuotes() map[string]map[string]basketQuote {
+	return map[string]map[string]basketQuote{
+		"Blinkit": {"Milk": {price: 60, deliveryTime: 12}, "Bread": {price: 30, deliveryTime: 12}, "Rice": {price: 260, deliveryTime: 15}},
+		"Zepto":   {"Milk": {price: 50, deliveryTime: 10}, "Bread": {price: 45, deliveryTime: 10}, "Rice": {price: 300, deliveryTime: 11}},
+	}
+}
+
+var testBasketItems = []BasketItem{{Item: "Milk", Quantity: 4}, {Item: "Bread", Quantity: 2}, {Item: "Rice", Quantity: 1}}
+
+func TestPriceBasket(t *testing.T) {
+	quotes := testBasketQuotes()["Zepto"]
+
+	order := priceBasket("Zepto", testBasketItems, quotes)
+	want := []BasketLine{
+		{Item: "Milk", Quantity: 4, UnitPrice: 50, Total: 200},
+		{Item: "Bread", Quantity: 2, UnitPrice: 45, Total: 90},
+		{Item: "Rice", Quantity: 1, UnitPrice: 300, Total: 300},
+	}
+	if !reflect.DeepEqual(order.Lines, want) {
+		t.Errorf("lines = %+v", order.Lines)
+	}
+	// The platform fee and its GST are charged once per order
+	if order.Subtotal != 590 || order.DeliveryFee != 0 || order.PlatformFee != 4 || order.FeeGST != 0.2 || order.Total != 594.2 || order.DeliveryTime != 11 {
+		t.Errorf("order = %+v, want 590 delivered free in 11 minutes plus a 4.2 platform fee", order)
+	}
+
+	// Below the threshold the delivery fee is charged once per order too
+	small := priceBasket("Zepto", []BasketItem{{Item: "Bread", Quantity: 3}}, quotes)
+	if small.Subtotal != 135 || small.DeliveryFee != 25 || small.FeeGST != 1.45 || small.Total != 165.45 {
+		t.Errorf("small order = %+v, want 135 plus 29 in fees and their GST", small)
+	}
+}
+
+func TestCheapestSplit(t *testing.T) {
+	quotes := testBasketQuotes()
+	providers := sortedKeys(quotes)
+	singles := []ProviderBasket{
+		priceBasket("Blinkit", testBasketItems, quotes["Blinkit"]),
+		priceBasket("Zepto", testBasketItems, quotes["Zepto"]),
+	}
+	if singles[0].Total != 562.1 || singles[1].Total != 594.2 {
+		t.Fatalf("single provider totals = %v and %v, want 562.1 and 594.2", singles[0].Total, singles[1].Total)
+	}
+
+	split := cheapestSplit(testBasketItems, providers, quotes, singles)
+	if split == nil {
+		t.Fatal("no split found")
+	}
+	if split.Total != 526.3 || split.Savings != 35.8 || len(split.Orders) != 2 {
+		t.Fatalf("split = %+v, want 526.3 over two orders, saving 35.8", split)
+	}
+	got := map[string][]string{}
+	for _, order := range split.Orders {
+		for _, line := range order.Lines {
+			got[order.Provider] = append(got[order.Provider], line.Item)
+		}
+	}
+	if want := map[string][]string{"Blinkit": {"Bread", "Rice"}, "Zepto": {"Milk"}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("split orders = %v, want %v", got, want)
+	}
+
+	// An item nobody sells cannot be split
+	if split := cheapestSplit([]BasketItem{{Item: "Caviar", Quantity: 1}}, providers, quotes, singles); split != nil {
+		t.Errorf("split for an unknown item = %+v", split)
+	}
+}
+
+// Set the grocery catalog for the length of a test
+func useTestGroceryItems(t *testing.T, items ...string) {
+	t.Helper()
+
+	old, existed := locationOptions["groceryItems"]
+	t.Cleanup(func() {
+		if existed {
+			locationOptions["groceryItems"] = old
+		} else {
+			delete(locationOptions, "groceryItems")
+		}
+	})
+	locationOptions["groceryItems"] = map[string][]string{"items": items}
+}
+
+func postBasket(body string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	compareBasket(w, httptest.NewRequest("POST", "/api/compare/quickcommerce/basket", strings.NewReader(body)))
+	return w
+}
+
+func TestCompareBasket(t *testing.T) {
+	useTestProviders(t,
+		&fakeProvider{name: "Zepto", category: CategoryQuickCommerce, price: 80, duration: 10},
+		&fakeProvider{name: "Blinkit", category: CategoryQuickCommerce, price: 90, duration: 12})
+	useTestGroceryItems(t, "Milk (1L)", "Rice (5kg)")
+	useTestCatalog(t, "addresses", map[string]map[string][]string{"Punjab": {"Patiala": {"Main Market"}}})
+
+	w := postBasket(`{"country":"india","state":"punjab","city":"patiala","address":"main market",
+		"items":[{"item":"milk (1l)","quantity":2},{"item":"Rice (5kg)","quantity":3}]}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", w.Code, w.Body)
+	}
+	var response BasketResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+
+	if len(response.Providers) != 2 || response.Providers[0].Provider != "Zepto" {
+		t.Fatalf("providers = %+v, want Zepto first", response.Providers)
+	}
+	// Zepto's quote of 80 has an item part of 47.19, which with its GST
+	// makes a unit price of 49.55. The fees in the quote are charged once
+	// for the whole basket, not per unit.
+	zepto := response.Providers[0]
+	wantLines := []BasketLine{
+		{Item: "Milk (1L)", Quantity: 2, UnitPrice: 49.55, Total: 99.1},
+		{Item: "Rice (5kg)", Quantity: 3, UnitPrice: 49.55, Total: 148.65},
+	}
+	if !reflect.DeepEqual(zepto.Lines, wantLines) {
+		t.Errorf("Zepto lines = %+v", zepto.Lines)
+	}
+	if zepto.Subtotal != 247.75 || zepto.DeliveryFee != 0 || zepto.PlatformFee != 4 || zepto.FeeGST != 0.2 || zepto.Total != 251.95 {
+		t.Errorf("Zepto basket = %+v, want 247.75 delivered free plus a 4.2 platform fee", zepto)
+	}
+	if response.Cheapest == nil || response.Cheapest.Total != 251.95 || response.Cheapest.Savings != 0 {
+		t.Errorf("cheapest = %+v, want the Zepto basket", response.Cheapest)
+	}
+}
+
+func TestCompareBasketValidation(t *testing.T) {
+	useTestGroceryItems(t, "Milk (1L)")
+
+	w := postBasket(`{"state":"punjab","city":"patiala",
+		"items":[{"item":"Milk (1L)","quantity":0},{"item":" ","quantity":1},{"item":"milk (1l)","quantity":1}]}`)
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("status %d, want 400", w.Code)
+	}
+	fields := decodeError(t, w).Details.Fields
+	for field, want := range map[string]string{"address": "is required", "items[0]": "quantity", "items[1]": "item is required", "items[2]": `"milk (1l)" is listed more than once`} {
+		if !strings.Contains(fields[field], want) {
+			t.Errorf("%s = %q, want %q", field, fields[field], want)
+		}
+	}
+
+	// Items are checked against the catalog once the fields are valid
+	useTestCatalog(t, "addresses", map[string]map[string][]string{"Punjab": {"Patiala": {"Main Market"}}})
+	w = postBasket(`{"country":"india","state":"punjab","city":"patiala","address":"main market",
+		"items":[{"item":"Milk (1L)","quantity":1},{"item":"Caviar","quantity":1}]}`)
+	if w.Code != http.StatusNotFound || !strings.Contains(decodeError(t, w).Details.Fields["items[1]"], `unknown grocery item "Caviar"`) {
+		t.Errorf("basket with an unknown item: status %d: %s", w.Code, w.Body)
+	}
+}
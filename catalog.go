@@ -0,0 +1,291 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Most suggestions offered for an unknown value
+const maxSuggestions = 3
+
+// Short lists, such as the restaurants of a city, are given in full when
+// nothing in them is close to an unknown value
+const maxListedChoices = 8
+
+// lenientCatalog accepts requests for places, restaurants and grocery items
+// missing from the catalog. It is set in main from the configuration.
+var lenientCatalog bool
+
+// catalogCheck collects the request fields whose values the catalog does
+// not know
+type catalogCheck struct {
+	fields      map[string]string
+	suggestions map[string][]string
+}
+
+func newCatalogCheck() *catalogCheck {
+	return &catalogCheck{
+		fields:      make(map[string]string),
+		suggestions: make(map[string][]string),
+	}
+}
+
+// The not_found error for the unknown values, if there are any
+func (c *catalogCheck) err() *ProtocolError {
+	if len(c.fields) == 0 {
+		return nil
+	}
+	return &ProtocolError{
+		Code:    ErrCodeNotFound,
+		Message: "request names something that is not in the catalog",
+		Details: &ErrorDetails{
+			Fields:      c.fields,
+			Suggestions: c.suggestions,
+		},
+	}
+}
+
+// Record an unknown value, suggesting the closest known ones
+func (c *catalogCheck) unknown(field, kind, value string, known []string) {
+	message := fmt.Sprintf("unknown %s %q", kind, value)
+	quote := func(values []string) []string {
+		quoted := make([]string, len(values))
+		for i, v := range values {
+			quoted[i] = fmt.Sprintf("%q", v)
+		}
+		return quoted
+	}
+
+	if suggestions := suggest(value, known); len(suggestions) > 0 {
+		message += "; did you mean " + strings.Join(quote(suggestions), " or ") + "?"
+		c.suggestions[field] = suggestions
+	} else if len(known) > 0 && len(known) <= maxListedChoices {
+		message += "; choose one of " + strings.Join(quote(known), ", ")
+		c.suggestions[field] = known
+	}
+	c.fields[field] = message
+}
+
+// Check one place: a country, state, city and address, each optional but
+// only checked when the ones before it are known. Field names get prefix
+// ("from", "to") when it is set.
+func (c *catalogCheck) place(prefix, country, state, city, address string) {
+	field := func(name string) string {
+		if prefix == "" {
+			return name
+		}
+		return prefix + strings.ToUpper(name[:1]) + name[1:]
+	}
+
+	countries := locationOptions["countries"].([]string)
+	states := locationOptions["states"].(map[string][]string)
+	cities := locationOptions["cities"].(map[string]map[string][]string)
+
+	if country != "" {
+		known, ok := findFold(countries, country)
+		if !ok {
+			c.unknown(field("country"), "country", country, countries)
+			return
+		}
+		country = known
+	}
+	if state == "" {
+		return
+	}
+
+	// Without a country, a state of any country will do
+	var knownStates []string
+	for _, name := range sortedKeys(states) {
+		if country == "" || name == country {
+			knownStates = append(knownStates, states[name]...)
+		}
+	}
+	knownState, ok := findFold(knownStates, state)
+	if !ok {
+		c.unknown(field("state"), "state", state, knownStates)
+		return
+	}
+	if city == "" {
+		return
+	}
+
+	var knownCities []string
+	for _, name := range sortedKeys(cities) {
+		if country == "" || name == country {
+			knownCities = append(knownCities, cities[name][knownState]...)
+		}
+	}
+	knownCity, ok := findFold(knownCities, city)
+	if !ok {
+		c.unknown(field("city"), "city", city, knownCities)
+		return
+	}
+
+	if address != "" {
+		addresses := catalogEntries("addresses", knownState, knownCity)
+		if !containsFold(addresses, address) {
+			c.unknown(field("address"), "address", address, addresses)
+		}
+	}
+}
+
+// Check that both ends of a taxi trip have gazetteer coordinates, which
+// its fare needs even when the catalog is lenient
+func (c *catalogCheck) taxiEnds(request RealTimeRequest) {
+	if _, ok := taxiEndpoint(request.FromCountry, request.FromState, request.FromCity, request.FromAddress); !ok {
+		c.fields["fromState"] = fmt.Sprintf("no coordinates for %q, so no fare can be quoted", request.FromState)
+	}
+	if _, ok := taxiEndpoint(request.ToCountry, request.ToState, request.ToCity, request.ToAddress); !ok {
+		c.fields["toState"] = fmt.Sprintf("no coordinates for %q, so no fare can be quoted", request.ToState)
+	}
+}
+
+// Check the places, restaurant and grocery item of a request against
+// locationOptions and the generated catalogs. Empty fields are left to
+// validation. In lenient mode only taxi trips are checked, against the
+// gazetteer.
+func checkCatalog(request RealTimeRequest) *ProtocolError {
+	c := newCatalogCheck()
+	if lenientCatalog {
+		if request.Category == CategoryTaxi {
+			c.taxiEnds(request)
+		}
+		return c.err()
+	}
+
+	switch request.Category {
+	case CategoryTaxi:
+		c.place("from", request.FromCountry, request.FromState, request.FromCity, request.FromAddress)
+		c.place("to", request.ToCountry, request.ToState, request.ToCity, request.ToAddress)
+	case CategoryRestaurant:
+		c.place("", request.Country, request.State, request.City, "")
+		if len(c.fields) == 0 && request.State != "" && request.City != "" && request.Restaurant != "" {
+			restaurants := catalogEntries("restaurants", request.State, request.City)
+			if !containsFold(restaurants, request.Restaurant) {
+				c.unknown("restaurant", "restaurant", request.Restaurant, restaurants)
+			}
+		}
+	case CategoryQuickCommerce:
+		c.place("", request.Country, request.State, request.City, request.Address)
+		if request.GroceryItem != "" {
+			items := locationOptions["groceryItems"].(map[string][]string)["items"]
+			if !containsFold(items, request.GroceryItem) {
+				c.unknown("groceryItem", "grocery item", request.GroceryItem, items)
+			}
+		}
+	}
+	return c.err()
+}
+
+// Check the place and items of a basket like checkCatalog, reporting unknown
+// items by their index in items
+func checkBasketCatalog(request BasketRequest) *ProtocolError {
+	if lenientCatalog {
+		return nil
+	}
+
+	c := newCatalogCheck()
+	c.place("", request.Country, request.State, request.City, request.Address)
+	items := locationOptions["groceryItems"].(map[string][]string)["items"]
+	for i, line := range request.Items {
+		if !containsFold(items, line.Item) {
+			c.unknown(fmt.Sprintf("items[%d]", i), "grocery item", line.Item, items)
+		}
+	}
+	return c.err()
+}
+
+// Find a value in a list ignoring case, returning the listed spelling
+func findFold(list []string, value string) (string, bool) {
+	for _, item := range list {
+		if strings.EqualFold(item, value) {
+			return item, true
+		}
+	}
+	return "", false
+}
+
+// Known values close to an unknown one: those within a few typos of it, and
+// those that contain it or that it contains, closest first
+func suggest(value string, known []string) []string {
+	value = strings.ToLower(strings.TrimSpace(value))
+	if value == "" {
+		return nil
+	}
+
+	// Allow one typo for every three characters, and at least two
+	limit := len([]rune(value)) / 3
+	if limit < 2 {
+		limit = 2
+	}
+
+	type candidate struct {
+		name     string
+		distance int
+	}
+	var candidates []candidate
+	seen := make(map[string]bool)
+	for _, name := range known {
+		lower := strings.ToLower(name)
+		if seen[lower] {
+			continue
+		}
+		seen[lower] = true
+
+		distance := editDistance(value, lower)
+		if distance > limit && !strings.Contains(lower, value) && !strings.Contains(value, lower) {
+			continue
+		}
+		candidates = append(candidates, candidate{name, distance})
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		if candidates[i].distance != candidates[j].distance {
+			return candidates[i].distance < candidates[j].distance
+		}
+		return candidates[i].name < candidates[j].name
+	})
+	if len(candidates) > maxSuggestions {
+		candidates = candidates[:maxSuggestions]
+	}
+
+	suggestions := make([]string, len(candidates))
+	for i, c := range candidates {
+		suggestions[i] = c.name
+	}
+	return suggestions
+}
+
+// Levenshtein distance between two strings, counted in runes
+func editDistance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	previous := make([]int, len(rb)+1)
+	current := make([]int, len(rb)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+
+	for i := 1; i <= len(ra); i++ {
+		current[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			current[j] = minInt(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous, current = current, previous
+	}
+	return previous[len(rb)]
+}
+
+func minInt(values ...int) int {
+	result := values[0]
+	for _, v := range values[1:] {
+		if v < result {
+			result = v
+		}
+	}
+	return result
+}
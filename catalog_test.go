@@ -0,0 +1,156 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// Set lenientCatalog for the length of a test
+func useLenientCatalog(t *testing.T, lenient bool) {
+	t.Helper()
+
+	old := lenientCatalog
+	t.Cleanup(func() { lenientCatalog = old })
+	lenientCatalog = lenient
+}
+
+func TestEditDistance(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"", "", 0},
+		{"kfc", "", 3},
+		{"haldirams", "haldiram's", 1},
+		{"punjab", "panjab", 1},
+		{"kerala", "karnataka", 5},
+		{"पंजाब", "पंजाब", 0},
+	}
+	for _, tt := range tests {
+		if got := editDistance(tt.a, tt.b); got != tt.want {
+			t.Errorf("editDistance(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestSuggest(t *testing.T) {
+	known := []string{"Haldiram's", "KFC", "Pizza Hut", "Dominos", "Biryani Blues", "Behrouz Biryani", "Paradise Biryani"}
+	tests := []struct {
+		value string
+		want  []string
+	}{
+		{"haldirams", []string{"Haldiram's"}},
+		{"domino", []string{"Dominos"}},
+		// Substrings match however far they are, closest first, and at
+		// most maxSuggestions are given
+		{"biryani", []string{"Biryani Blues", "Behrouz Biryani", "Paradise Biryani"}},
+		{"sushi bar", []string{}},
+		{"  ", nil},
+	}
+	for _, tt := range tests {
+		if got := suggest(tt.value, known); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("suggest(%q) = %q, want %q", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestCheckCatalog(t *testing.T) {
+	useLenientCatalog(t, false)
+	useTestCatalog(t, "restaurants", map[string]map[string][]string{"Punjab": {"Patiala": {"Dominos", "KFC"}}})
+
+	request := RealTimeRequest{Category: CategoryRestaurant, Country: "india", State: "punjab", City: "patiala", Restaurant: "kfc"}
+	if perr := checkCatalog(request); perr != nil {
+		t.Fatalf("known restaurant rejected: %+v", perr)
+	}
+
+	// Short lists are given in full when nothing is close
+	request.Restaurant = "noma"
+	perr := checkCatalog(request)
+	if perr == nil || perr.Code != ErrCodeNotFound || !reflect.DeepEqual(perr.Details.Suggestions["restaurant"], []string{"Dominos", "KFC"}) {
+		t.Errorf("unknown restaurant = %+v, want every restaurant of the city suggested", perr)
+	}
+
+	// Places are checked from the country down, and only the first
+	// unknown one is reported
+	request.State, request.City = "panjab", "atlantis"
+	perr = checkCatalog(request)
+	if perr == nil || len(perr.Details.Fields) != 1 || !reflect.DeepEqual(perr.Details.Suggestions["state"], []string{"Punjab"}) {
+		t.Errorf("misspelt state = %+v, want Punjab suggested", perr)
+	}
+
+	taxi := testTaxiRequest()
+	taxi.ToState = "dehli"
+	perr = checkCatalog(taxi)
+	if perr == nil || !strings.Contains(perr.Details.Fields["toState"], `did you mean "Delhi"?`) {
+		t.Errorf("misspelt taxi destination = %+v", perr)
+	}
+}
+
+func TestLenientCatalogStillPlacesTaxiTrips(t *testing.T) {
+	useBundledGazetteer(t)
+	useLenientCatalog(t, true)
+
+	request := RealTimeRequest{Category: CategoryRestaurant, Country: "india", State: "punjab", City: "patiala", Restaurant: "noma"}
+	if perr := checkCatalog(request); perr != nil {
+		t.Errorf("lenient catalog rejected a restaurant: %+v", perr)
+	}
+	if perr := checkCatalog(testTaxiRequest()); perr != nil {
+		t.Errorf("lenient catalog rejected a known route: %+v", perr)
+	}
+
+	// A taxi trip cannot be priced without coordinates for both ends
+	taxi := testTaxiRequest()
+	taxi.FromState, taxi.ToState = "atlantis", "lemuria"
+	perr := checkCatalog(taxi)
+	if perr == nil || perr.Code != ErrCodeNotFound || !strings.Contains(perr.Details.Fields["fromState"], "atlantis") || !strings.Contains(perr.Details.Fields["toState"], "lemuria") {
+		t.Fatalf("route the gazetteer cannot place = %+v, want fromState and toState reported", perr)
+	}
+
+	useTestProviders(t, &fakeProvider{name: "Uber", category: CategoryTaxi, price: 500})
+	w := httptest.NewRecorder()
+	compareTaxi(w, httptest.NewRequest("GET", "/api/compare/taxi?fromCountry=india&fromState=punjab&toCountry=india&toState=lemuria", nil))
+	if w.Code != http.StatusNotFound || decodeError(t, w).Details.Fields["toState"] == "" {
+		t.Errorf("status %d: %s, want 404 naming toState", w.Code, w.Body)
+	}
+}
+
+func TestCompareValidatesBeforeCatalog(t *testing.T) {
+	useLenientCatalog(t, false)
+
+	// Field errors win over unknown places
+	w := httptest.NewRecorder()
+	compareRestaurant(w, httptest.NewRequest("GET", "/api/compare/restaurant?country=india&state=atlantis", nil))
+	if w.Code != http.StatusBadRequest || decodeError(t, w).Details.Fields["city"] != "is required" {
+		t.Errorf("status %d: %s, want 400 with field errors", w.Code, w.Body)
+	}
+
+	w = httptest.NewRecorder()
+	compareRestaurant(w, httptest.NewRequest("GET", "/api/compare/restaurant?country=india&state=atlantis&city=x&restaurant=y", nil))
+	if w.Code != http.StatusNotFound || !strings.Contains(decodeError(t, w).Details.Fields["state"], `unknown state "atlantis"`) {
+		t.Errorf("status %d: %s, want 404 for the unknown state", w.Code, w.Body)
+	}
+}
+
+func TestCompareDishesChecksRestaurant(t *testing.T) {
+	useLenientCatalog(t, false)
+	useBundledMenus(t)
+	useTestCatalog(t, "restaurants", map[string]map[string][]string{"Punjab": {"Patiala": {"Dominos", "Noma"}}})
+
+	post := func(restaurant string) *httptest.ResponseRecorder {
+		body := `{"country":"india","state":"punjab","city":"patiala","restaurant":"` + restaurant + `",
+			"items":[{"dish":"Margherita","variant":"Medium","quantity":1}]}`
+		w := httptest.NewRecorder()
+		compareDishes(w, httptest.NewRequest("POST", "/api/compare/restaurant/dishes", strings.NewReader(body)))
+		return w
+	}
+
+	if w := post("Sushi Bar"); w.Code != http.StatusNotFound || !strings.Contains(decodeError(t, w).Details.Fields["restaurant"], `unknown restaurant "Sushi Bar"`) {
+		t.Errorf("restaurant outside the catalog: status %d: %s", w.Code, w.Body)
+	}
+	if w := post("Noma"); w.Code != http.StatusNotFound || !strings.Contains(decodeError(t, w).Details.Fields["restaurant"], `no menu for "Noma"`) {
+		t.Errorf("restaurant without a menu: status %d: %s", w.Code, w.Body)
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// Clock tells the current time. Handlers, the hub and the price updates read
+// the time through a Clock so that it can be fixed in tests and demos.
+type Clock interface {
+	Now() time.Time
+}
+
+// systemClock is the wall clock
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+// startedClock starts at a chosen time and then advances with the wall clock
+type startedClock struct {
+	start   time.Time
+	started time.Time
+}
+
+// Return a clock that reads start now and moves on in real time
+func startClockAt(start time.Time) Clock {
+	return startedClock{start: start, started: time.Now()}
+}
+
+func (c startedClock) Now() time.Time {
+	return c.start.Add(time.Since(c.started))
+}
+
+// RandomSource supplies the random numbers used to generate prices and
+// catalogs. Implementations must be safe for concurrent use.
+type RandomSource interface {
+	// Float64 returns a number in [0.0, 1.0)
+	Float64() float64
+	// Intn returns a number in [0, n)
+	Intn(n int) int
+	// Int63 returns a non-negative 63-bit integer
+	Int63() int64
+}
+
+// lockedRandom is a math/rand generator guarded by a mutex
+type lockedRandom struct {
+	mu  sync.Mutex
+	rnd *rand.Rand
+}
+
+// NewRandomSource returns a goroutine-safe source that produces the same
+// sequence for the same seed
+func NewRandomSource(seed int64) RandomSource {
+	return &lockedRandom{rnd: rand.New(rand.NewSource(seed))}
+}
+
+func (r *lockedRandom) Float64() float64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.rnd.Float64()
+}
+
+func (r *lockedRandom) Intn(n int) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.rnd.Intn(n)
+}
+
+func (r *lockedRandom) Int63() int64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.rnd.Int63()
+}
+
+// Derive an independent source from a parent. Giving each consumer its own
+// source keeps, say, the catalogs the same no matter how many quotes were
+// made before they were generated.
+func forkRandomSource(parent RandomSource) RandomSource {
+	return NewRandomSource(parent.Int63())
+}
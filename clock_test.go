@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeClock is a Clock that only moves when a test advances it
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Date(2024, 1, 15, 9, 0, 0, 0, time.UTC)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+func TestRandomSourceRepeatsForSeed(t *testing.T) {
+	a, b := NewRandomSource(42), NewRandomSource(42)
+	for i := 0; i < 100; i++ {
+		if x, y := a.Float64(), b.Float64(); x != y {
+			t.Fatalf("draw %d: got %v and %v from the same seed", i, x, y)
+		}
+	}
+}
+
+func TestForkRandomSourceRepeatsForSeed(t *testing.T) {
+	a, b := forkRandomSource(NewRandomSource(7)), forkRandomSource(NewRandomSource(7))
+	for i := 0; i < 10; i++ {
+		if x, y := a.Int63(), b.Int63(); x != y {
+			t.Fatalf("draw %d: got %d and %d from forks of the same seed", i, x, y)
+		}
+	}
+}
+
+func TestStartedClockAdvances(t *testing.T) {
+	start := time.Date(2024, 1, 15, 9, 0, 0, 0, time.UTC)
+	clock := startClockAt(start)
+
+	first := clock.Now()
+	if first.Before(start) || first.Sub(start) > time.Second {
+		t.Fatalf("clock started at %v, want %v", first, start)
+	}
+	time.Sleep(10 * time.Millisecond)
+	if second := clock.Now(); !second.After(first) {
+		t.Errorf("clock did not move: %v then %v", first, second)
+	}
+}
+
+// The same seed must produce the same quotes
+func TestProviderQuotesRepeatForSeed(t *testing.T) {
+	request := RealTimeRequest{
+		Category:   CategoryRestaurant,
+		Country:    "India",
+		State:      "Punjab",
+		City:       "Patiala",
+		Restaurant: "Dominos",
+	}
+
+	quote := func() []ServiceOffer {
+		registry := NewProviderRegistry()
+		registerDefaultProviders(registry, NewRandomSource(7))
+		var offers []ServiceOffer
+		for i := 0; i < 3; i++ {
+			quoted, err := registry.Quote(context.Background(), request)
+			if err != nil {
+				t.Fatalf("quote: %v", err)
+			}
+			offers = append(offers, quoted...)
+		}
+		return offers
+	}
+
+	first, second := quote(), quote()
+	if !reflect.DeepEqual(first, second) {
+		t.Fatalf("quotes differ for the same seed:\n%+v\n%+v", first, second)
+	}
+}
@@ -0,0 +1,538 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"gopkg.in/yaml.v3"
+)
+
+// Prefix of the environment variables read by loadConfig
+const envPrefix = "STEALXDEAL_"
+
+// Duration is a time.Duration written as a string such as "5s" in config files
+type Duration struct {
+	time.Duration
+}
+
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(d.String()), nil
+}
+
+func (d *Duration) UnmarshalText(text []byte) error {
+	parsed, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	d.Duration = parsed
+	return nil
+}
+
+// Config holds the runtime settings of the server
+type Config struct {
+	// Address the HTTP server listens on
+	Addr string `json:"addr" yaml:"addr"`
+	// Directory served as the frontend
+	FrontendDir string `json:"frontendDir" yaml:"frontendDir"`
+	// Time allowed on shutdown to drain requests and close connections
+	ShutdownTimeout Duration `json:"shutdownTimeout" yaml:"shutdownTimeout"`
+
+	// Interval between price updates
+	UpdateInterval Duration `json:"updateInterval" yaml:"updateInterval"`
+	// Maximum price change per update, in percent either way
+	FluctuationPercent float64 `json:"fluctuationPercent" yaml:"fluctuationPercent"`
+	// Fluctuation model of each category: walk, mean-reverting,
+	// time-of-day or frozen
+	FluctuationModels map[string]string `json:"fluctuationModels" yaml:"fluctuationModels"`
+	// Seed of every generated price and catalog. 0 picks a new seed on
+	// every start.
+	Seed int64 `json:"seed" yaml:"seed"`
+	// Time the server clock starts at, in RFC 3339 format; it then advances
+	// in real time. Empty uses the wall clock.
+	StartTime string `json:"startTime" yaml:"startTime"`
+
+	// Storage driver and the database file of the bolt driver
+	Storage string `json:"storage" yaml:"storage"`
+	DBPath  string `json:"dbPath" yaml:"dbPath"`
+
+	WebSocket WebSocketConfig `json:"websocket" yaml:"websocket"`
+
+	// Demand-based taxi surge pricing
+	Surge SurgeConfig `json:"surge" yaml:"surge"`
+
+	// Accept places, restaurants and grocery items that are not in the
+	// catalog instead of rejecting them with 404
+	LenientCatalog bool `json:"lenientCatalog" yaml:"lenientCatalog"`
+}
+
+// WebSocketConfig holds the settings of the /ws endpoint
+type WebSocketConfig struct {
+	ReadBufferSize  int `json:"readBufferSize" yaml:"readBufferSize"`
+	WriteBufferSize int `json:"writeBufferSize" yaml:"writeBufferSize"`
+	// Origins allowed to connect, such as "https://example.com". "*" allows
+	// every origin; an empty list only allows same-origin requests.
+	AllowedOrigins     []string `json:"allowedOrigins" yaml:"allowedOrigins"`
+	SendQueueSize      int      `json:"sendQueueSize" yaml:"sendQueueSize"`
+	WriteTimeout       Duration `json:"writeTimeout" yaml:"writeTimeout"`
+	PongTimeout        Duration `json:"pongTimeout" yaml:"pongTimeout"`
+	SlowConsumerPolicy string   `json:"slowConsumerPolicy" yaml:"slowConsumerPolicy"`
+}
+
+// Default settings, matching the behaviour before configuration existed
+func defaultConfig() Config {
+	return Config{
+		Addr:               "localhost:5000",
+		FrontendDir:        "./frontend",
+		ShutdownTimeout:    Duration{10 * time.Second},
+		UpdateInterval:     Duration{5 * time.Second},
+		FluctuationPercent: 5,
+		FluctuationModels: map[string]string{
+			CategoryTaxi:          FluctuationTimeOfDay,
+			CategoryRestaurant:    FluctuationMeanReverting,
+			CategoryQuickCommerce: FluctuationWalk,
+		},
+		Storage: StorageMemory,
+		DBPath:  "stealxdeal.db",
+		WebSocket: WebSocketConfig{
+			ReadBufferSize:     1024,
+			WriteBufferSize:    1024,
+			AllowedOrigins:     []string{"*"},
+			SendQueueSize:      64,
+			WriteTimeout:       Duration{10 * time.Second},
+			PongTimeout:        Duration{60 * time.Second},
+			SlowConsumerPolicy: SlowConsumerDrop,
+		},
+		Surge: SurgeConfig{
+			Window:             Duration{time.Minute},
+			RequestsPerStep:    5,
+			SubscribersPerStep: 2,
+			Step:               0.1,
+			Max:                2.5,
+			PeakHours: []PeakHours{
+				{Start: "08:00", End: "10:00", Multiplier: 1.2},
+				{Start: "17:30", End: "20:30", Multiplier: 1.3},
+			},
+			ProviderFactors: map[string]float64{"Uber": 1, "Ola": 0.8},
+		},
+	}
+}
+
+// configSetting binds one setting to its flag and environment variable
+type configSetting struct {
+	flag  string
+	env   string
+	usage string
+	set   func(cfg *Config, value string) error
+}
+
+func stringSetting(field func(*Config) *string) func(*Config, string) error {
+	return func(cfg *Config, value string) error {
+		*field(cfg) = value
+		return nil
+	}
+}
+
+func intSetting(field func(*Config) *int) func(*Config, string) error {
+	return func(cfg *Config, value string) error {
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("%q is not an integer", value)
+		}
+		*field(cfg) = n
+		return nil
+	}
+}
+
+func int64Setting(field func(*Config) *int64) func(*Config, string) error {
+	return func(cfg *Config, value string) error {
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return fmt.Errorf("%q is not an integer", value)
+		}
+		*field(cfg) = n
+		return nil
+	}
+}
+
+func floatSetting(field func(*Config) *float64) func(*Config, string) error {
+	return func(cfg *Config, value string) error {
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return fmt.Errorf("%q is not a number", value)
+		}
+		*field(cfg) = f
+		return nil
+	}
+}
+
+func boolSetting(field func(*Config) *bool) func(*Config, string) error {
+	return func(cfg *Config, value string) error {
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("%q is not true or false", value)
+		}
+		*field(cfg) = b
+		return nil
+	}
+}
+
+func durationSetting(field func(*Config) *Duration) func(*Config, string) error {
+	return func(cfg *Config, value string) error {
+		return field(cfg).UnmarshalText([]byte(value))
+	}
+}
+
+func listSetting(field func(*Config) *[]string) func(*Config, string) error {
+	return func(cfg *Config, value string) error {
+		var list []string
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				list = append(list, item)
+			}
+		}
+		*field(cfg) = list
+		return nil
+	}
+}
+
+// Parse category=value pairs into a map, keeping the categories not listed
+func mapSetting(field func(*Config) *map[string]string) func(*Config, string) error {
+	return func(cfg *Config, value string) error {
+		m := *field(cfg)
+		if m == nil {
+			m = make(map[string]string)
+			*field(cfg) = m
+		}
+		for _, pair := range strings.Split(value, ",") {
+			if pair = strings.TrimSpace(pair); pair == "" {
+				continue
+			}
+			name, v, ok := strings.Cut(pair, "=")
+			if !ok {
+				return fmt.Errorf("%q is not name=value", pair)
+			}
+			m[strings.TrimSpace(name)] = strings.TrimSpace(v)
+		}
+		return nil
+	}
+}
+
+// Parse name=number pairs into a map, keeping the names not listed
+func floatMapSetting(field func(*Config) *map[string]float64) func(*Config, string) error {
+	return func(cfg *Config, value string) error {
+		m := *field(cfg)
+		if m == nil {
+			m = make(map[string]float64)
+			*field(cfg) = m
+		}
+		for _, pair := range strings.Split(value, ",") {
+			if pair = strings.TrimSpace(pair); pair == "" {
+				continue
+			}
+			name, v, ok := strings.Cut(pair, "=")
+			if !ok {
+				return fmt.Errorf("%q is not name=number", pair)
+			}
+			f, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+			if err != nil {
+				return fmt.Errorf("%q is not a number", v)
+			}
+			m[strings.TrimSpace(name)] = f
+		}
+		return nil
+	}
+}
+
+// Parse start-end=multiplier windows such as 08:00-10:00=1.2, replacing the
+// schedule
+func peakHoursSetting(field func(*Config) *[]PeakHours) func(*Config, string) error {
+	return func(cfg *Config, value string) error {
+		var schedule []PeakHours
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item == "" {
+				continue
+			}
+			span, multiplier, ok := strings.Cut(item, "=")
+			start, end, ok2 := strings.Cut(span, "-")
+			if !ok || !ok2 {
+				return fmt.Errorf("%q is not HH:MM-HH:MM=multiplier", item)
+			}
+			m, err := strconv.ParseFloat(multiplier, 64)
+			if err != nil {
+				return fmt.Errorf("%q is not a number", multiplier)
+			}
+			schedule = append(schedule, PeakHours{Start: start, End: end, Multiplier: m})
+		}
+		*field(cfg) = schedule
+		return nil
+	}
+}
+
+var configSettings = []configSetting{
+	{"addr", "ADDR", "address to listen on", stringSetting(func(c *Config) *string { return &c.Addr })},
+	{"frontend-dir", "FRONTEND_DIR", "directory served as the frontend", stringSetting(func(c *Config) *string { return &c.FrontendDir })},
+	{"shutdown-timeout", "SHUTDOWN_TIMEOUT", "time allowed on shutdown to drain requests and close connections", durationSetting(func(c *Config) *Duration { return &c.ShutdownTimeout })},
+	{"update-interval", "UPDATE_INTERVAL", "interval between price updates", durationSetting(func(c *Config) *Duration { return &c.UpdateInterval })},
+	{"fluctuation", "FLUCTUATION_PERCENT", "maximum price change per update, in percent", floatSetting(func(c *Config) *float64 { return &c.FluctuationPercent })},
+	{"fluctuation-models", "FLUCTUATION_MODELS", "comma-separated category=model pairs (walk, mean-reverting, time-of-day or frozen)", mapSetting(func(c *Config) *map[string]string { return &c.FluctuationModels })},
+	{"seed", "SEED", "seed for generated prices and catalogs (0 for a random seed)", int64Setting(func(c *Config) *int64 { return &c.Seed })},
+	{"start-time", "START_TIME", "RFC 3339 time the server clock starts at, such as 2024-01-15T09:00:00+05:30 (empty for the wall clock)", stringSetting(func(c *Config) *string { return &c.StartTime })},
+	{"storage", "STORAGE", "storage driver for offers and catalogs (memory or bolt)", stringSetting(func(c *Config) *string { return &c.Storage })},
+	{"db", "DB_PATH", "database file used by the bolt storage driver", stringSetting(func(c *Config) *string { return &c.DBPath })},
+	{"ws-read-buffer", "WS_READ_BUFFER", "WebSocket read buffer size in bytes", intSetting(func(c *Config) *int { return &c.WebSocket.ReadBufferSize })},
+	{"ws-write-buffer", "WS_WRITE_BUFFER", "WebSocket write buffer size in bytes", intSetting(func(c *Config) *int { return &c.WebSocket.WriteBufferSize })},
+	{"ws-allowed-origins", "WS_ALLOWED_ORIGINS", "comma-separated origins allowed to open WebSockets (* for any)", listSetting(func(c *Config) *[]string { return &c.WebSocket.AllowedOrigins })},
+	{"ws-send-queue", "WS_SEND_QUEUE", "frames queued per WebSocket client before the slow consumer policy applies", intSetting(func(c *Config) *int { return &c.WebSocket.SendQueueSize })},
+	{"ws-write-timeout", "WS_WRITE_TIMEOUT", "time allowed to write one WebSocket frame", durationSetting(func(c *Config) *Duration { return &c.WebSocket.WriteTimeout })},
+	{"ws-pong-timeout", "WS_PONG_TIMEOUT", "time without a pong before a WebSocket is considered dead", durationSetting(func(c *Config) *Duration { return &c.WebSocket.PongTimeout })},
+	{"ws-slow-consumer", "WS_SLOW_CONSUMER", "what to do when a client's queue is full (drop or disconnect)", stringSetting(func(c *Config) *string { return &c.WebSocket.SlowConsumerPolicy })},
+	{"surge-window", "SURGE_WINDOW", "period over which taxi compare requests count towards surge", durationSetting(func(c *Config) *Duration { return &c.Surge.Window })},
+	{"surge-requests-per-step", "SURGE_REQUESTS_PER_STEP", "taxi compare requests per window that add one surge step (0 to ignore requests)", intSetting(func(c *Config) *int { return &c.Surge.RequestsPerStep })},
+	{"surge-subscribers-per-step", "SURGE_SUBSCRIBERS_PER_STEP", "live subscribers on a route that add one surge step (0 to ignore subscribers)", intSetting(func(c *Config) *int { return &c.Surge.SubscribersPerStep })},
+	{"surge-step", "SURGE_STEP", "surge multiplier increase per step", floatSetting(func(c *Config) *float64 { return &c.Surge.Step })},
+	{"surge-max", "SURGE_MAX", "highest surge multiplier", floatSetting(func(c *Config) *float64 { return &c.Surge.Max })},
+	{"surge-peak-hours", "SURGE_PEAK_HOURS", "comma-separated peak windows such as 08:00-10:00=1.2", peakHoursSetting(func(c *Config) *[]PeakHours { return &c.Surge.PeakHours })},
+	{"surge-provider-factors", "SURGE_PROVIDER_FACTORS", "comma-separated provider=factor pairs scaling how much each provider surges", floatMapSetting(func(c *Config) *map[string]float64 { return &c.Surge.ProviderFactors })},
+	{"lenient-catalog", "LENIENT_CATALOG", "accept places, restaurants and grocery items missing from the catalog (true or false)", boolSetting(func(c *Config) *bool { return &c.LenientCatalog })},
+}
+
+// Load the configuration. Later sources override earlier ones: built-in
+// defaults, then the config file (-config or STEALXDEAL_CONFIG), then
+// STEALXDEAL_* environment variables, then command-line flags.
+func loadConfig(args []string, getenv func(string) string) (Config, error) {
+	fs := flag.NewFlagSet("stealxdeal", flag.ContinueOnError)
+	configPath := fs.String("config", getenv(envPrefix+"CONFIG"), "path to a YAML or JSON config file")
+
+	flagValues := make(map[string]*string, len(configSettings))
+	for _, setting := range configSettings {
+		flagValues[setting.flag] = fs.String(setting.flag, "", setting.usage+" (env "+envPrefix+setting.env+")")
+	}
+	if err := fs.Parse(args); err != nil {
+		return Config{}, err
+	}
+
+	cfg := defaultConfig()
+
+	if *configPath != "" {
+		if err := cfg.loadFile(*configPath); err != nil {
+			return Config{}, err
+		}
+	}
+
+	for _, setting := range configSettings {
+		if value := getenv(envPrefix + setting.env); value != "" {
+			if err := setting.set(&cfg, value); err != nil {
+				return Config{}, fmt.Errorf("%s%s: %w", envPrefix, setting.env, err)
+			}
+		}
+	}
+
+	var flagErr error
+	fs.Visit(func(f *flag.Flag) {
+		value, ok := flagValues[f.Name]
+		if !ok || flagErr != nil {
+			return
+		}
+		for _, setting := range configSettings {
+			if setting.flag == f.Name {
+				if err := setting.set(&cfg, *value); err != nil {
+					flagErr = fmt.Errorf("-%s: %w", f.Name, err)
+				}
+			}
+		}
+	})
+	if flagErr != nil {
+		return Config{}, flagErr
+	}
+
+	if err := cfg.validate(); err != nil {
+		return Config{}, err
+	}
+	return cfg, nil
+}
+
+// Read a config file on top of the current settings. The format follows the
+// extension: .yaml/.yml or .json.
+func (c *Config) loadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("reading config: %w", err)
+	}
+
+	// Unknown keys are rejected so that typos do not go unnoticed
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		decoder := yaml.NewDecoder(bytes.NewReader(data))
+		decoder.KnownFields(true)
+		if err = decoder.Decode(c); err == io.EOF {
+			// Empty file
+			err = nil
+		}
+	case ".json":
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.DisallowUnknownFields()
+		err = decoder.Decode(c)
+	default:
+		return fmt.Errorf("config %s: unsupported format, use .yaml, .yml or .json", path)
+	}
+	if err != nil {
+		return fmt.Errorf("config %s: %w", path, err)
+	}
+	return nil
+}
+
+// Check every setting and report all problems at once
+func (c Config) validate() error {
+	var problems []string
+	add := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	if _, _, err := net.SplitHostPort(c.Addr); err != nil {
+		add("addr %q must be host:port", c.Addr)
+	}
+	if info, err := os.Stat(c.FrontendDir); err != nil || !info.IsDir() {
+		add("frontendDir %q is not a directory", c.FrontendDir)
+	}
+	if c.ShutdownTimeout.Duration <= 0 {
+		add("shutdownTimeout must be positive")
+	}
+	if c.UpdateInterval.Duration < 100*time.Millisecond {
+		add("updateInterval must be at least 100ms")
+	}
+	if c.FluctuationPercent < 0 || c.FluctuationPercent > 50 {
+		add("fluctuationPercent must be between 0 and 50")
+	}
+	if c.StartTime != "" {
+		if _, err := time.Parse(time.RFC3339, c.StartTime); err != nil {
+			add("startTime %q must be an RFC 3339 time such as 2024-01-15T09:00:00+05:30", c.StartTime)
+		}
+	}
+	for _, category := range sortedKeys(c.FluctuationModels) {
+		switch category {
+		case CategoryTaxi, CategoryRestaurant, CategoryQuickCommerce:
+		default:
+			add("fluctuationModels: unknown category %q", category)
+			continue
+		}
+		if _, err := newFluctuationModel(c.FluctuationModels[category], category, c.FluctuationPercent); err != nil {
+			add("fluctuationModels.%s: %v", category, err)
+		}
+	}
+	switch c.Storage {
+	case StorageMemory:
+	case StorageBolt:
+		if c.DBPath == "" {
+			add("dbPath is required for the bolt storage driver")
+		}
+	default:
+		add("storage must be %s or %s", StorageMemory, StorageBolt)
+	}
+
+	ws := c.WebSocket
+	if ws.ReadBufferSize <= 0 || ws.WriteBufferSize <= 0 {
+		add("websocket buffer sizes must be positive")
+	}
+	for _, origin := range ws.AllowedOrigins {
+		if origin == "*" {
+			continue
+		}
+		if u, err := url.Parse(origin); err != nil || u.Scheme == "" || u.Host == "" {
+			add("websocket origin %q must look like scheme://host[:port]", origin)
+		}
+	}
+	if ws.SendQueueSize <= 0 {
+		add("websocket sendQueueSize must be positive")
+	}
+	if ws.WriteTimeout.Duration <= 0 || ws.PongTimeout.Duration <= 0 {
+		add("websocket timeouts must be positive")
+	}
+	switch ws.SlowConsumerPolicy {
+	case SlowConsumerDrop, SlowConsumerDisconnect:
+	default:
+		add("websocket slowConsumerPolicy must be %s or %s", SlowConsumerDrop, SlowConsumerDisconnect)
+	}
+
+	c.Surge.validate(add)
+
+	if len(problems) > 0 {
+		return errors.New("invalid configuration: " + strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// Build the WebSocket upgrader for the configured buffers and origins
+func (c WebSocketConfig) upgrader() websocket.Upgrader {
+	upgrader := websocket.Upgrader{
+		ReadBufferSize:  c.ReadBufferSize,
+		WriteBufferSize: c.WriteBufferSize,
+		Error:           writeUpgradeError,
+	}
+
+	allowed := make(map[string]bool, len(c.AllowedOrigins))
+	for _, origin := range c.AllowedOrigins {
+		if origin == "*" {
+			upgrader.CheckOrigin = func(r *http.Request) bool { return true }
+			return upgrader
+		}
+		allowed[strings.ToLower(strings.TrimSuffix(origin, "/"))] = true
+	}
+
+	upgrader.CheckOrigin = func(r *http.Request) bool {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			// Not a browser request
+			return true
+		}
+		if allowed[strings.ToLower(origin)] {
+			return true
+		}
+		u, err := url.Parse(origin)
+		return err == nil && strings.EqualFold(u.Host, r.Host)
+	}
+	return upgrader
+}
+
+// Build the server clock: started at StartTime when it is set, otherwise the
+// wall clock
+func (c Config) clock() Clock {
+	if c.StartTime == "" {
+		return systemClock{}
+	}
+	// Checked by validate
+	start, _ := time.Parse(time.RFC3339, c.StartTime)
+	return startClockAt(start)
+}
+
+// Build the fluctuation model of every configured category
+func (c Config) fluctuationModels() map[string]FluctuationModel {
+	models := make(map[string]FluctuationModel, len(c.FluctuationModels))
+	for category, name := range c.FluctuationModels {
+		// Names were checked by validate
+		models[category], _ = newFluctuationModel(name, category, c.FluctuationPercent)
+	}
+	return models
+}
+
+// Options for the realtime hub
+func (c WebSocketConfig) hubOptions() HubOptions {
+	return HubOptions{
+		SendQueueSize:      c.SendQueueSize,
+		WriteWait:          c.WriteTimeout.Duration,
+		PongWait:           c.PongTimeout.Duration,
+		SlowConsumerPolicy: c.SlowConsumerPolicy,
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeConfigFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	return path
+}
+
+func TestLoadConfigPrecedence(t *testing.T) {
+	path := writeConfigFile(t, "config.yaml", `
+addr: "127.0.0.1:6000"
+updateInterval: 2s
+fluctuationPercent: 3
+websocket:
+  sendQueueSize: 16
+`)
+	env := map[string]string{
+		envPrefix + "CONFIG":              path,
+		envPrefix + "FLUCTUATION_PERCENT": "4",
+		envPrefix + "WS_SEND_QUEUE":       "32",
+	}
+	getenv := func(key string) string { return env[key] }
+
+	cfg, err := loadConfig([]string{"-ws-send-queue", "8"}, getenv)
+	if err != nil {
+		t.Fatalf("loadConfig: %v", err)
+	}
+	if cfg.Addr != "127.0.0.1:6000" || cfg.UpdateInterval.Duration != 2*time.Second {
+		t.Errorf("file settings not applied: addr %q, interval %v", cfg.Addr, cfg.UpdateInterval)
+	}
+	if cfg.FluctuationPercent != 4 {
+		t.Errorf("fluctuation = %v, want the environment's 4", cfg.FluctuationPercent)
+	}
+	if cfg.WebSocket.SendQueueSize != 8 {
+		t.Errorf("send queue = %d, want the flag's 8", cfg.WebSocket.SendQueueSize)
+	}
+	if cfg.WebSocket.PongTimeout.Duration != 60*time.Second || cfg.Storage != StorageMemory {
+		t.Errorf("unset settings lost their defaults: %+v", cfg)
+	}
+}
+
+func TestLoadConfigFile(t *testing.T) {
+	getenv := func(string) string { return "" }
+
+	path := writeConfigFile(t, "config.json", `{"websocket": {"allowedOrigins": ["https://example.com"]}}`)
+	cfg, err := loadConfig([]string{"-config", path}, getenv)
+	if err != nil {
+		t.Fatalf("loadConfig: %v", err)
+	}
+	if len(cfg.WebSocket.AllowedOrigins) != 1 || cfg.WebSocket.AllowedOrigins[0] != "https://example.com" {
+		t.Errorf("origins = %v", cfg.WebSocket.AllowedOrigins)
+	}
+
+	for name, content := range map[string]string{
+		"typo.yaml":  "adr: localhost:5000\n",
+		"typo.json":  `{"adr": "localhost:5000"}`,
+		"config.ini": "addr = localhost:5000\n",
+	} {
+		if _, err := loadConfig([]string{"-config", writeConfigFile(t, name, content)}, getenv); err == nil {
+			t.Errorf("loadConfig accepted %s", name)
+		}
+	}
+}
+
+func TestConfigValidateReportsEveryProblem(t *testing.T) {
+	getenv := func(string) string { return "" }
+
+	_, err := loadConfig([]string{"-addr", "nowhere", "-fluctuation", "80", "-storage", "postgres", "-ws-slow-consumer", "wait"}, getenv)
+	if err == nil {
+		t.Fatal("loadConfig accepted invalid settings")
+	}
+	for _, want := range []string{"addr", "fluctuationPercent", "storage", "slowConsumerPolicy"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q does not mention %s", err, want)
+		}
+	}
+
+	if _, err := loadConfig([]string{"-ws-send-queue", "lots"}, getenv); err == nil || !strings.Contains(err.Error(), "-ws-send-queue") {
+		t.Errorf("error for a malformed flag = %v, want it to name the flag", err)
+	}
+}
+
+func TestUpgraderChecksOrigin(t *testing.T) {
+	upgrader := WebSocketConfig{AllowedOrigins: []string{"https://example.com/"}}.upgrader()
+
+	tests := []struct {
+		origin string
+		want   bool
+	}{
+		{"", true},
+		{"https://example.com", true},
+		{"https://EXAMPLE.com", true},
+		{"http://localhost:5000", true}, // same origin
+		{"https://evil.example", false},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "http://localhost:5000/ws", nil)
+		if tt.origin != "" {
+			r.Header.Set("Origin", tt.origin)
+		}
+		if got := upgrader.CheckOrigin(r); got != tt.want {
+			t.Errorf("CheckOrigin(%q) = %v, want %v", tt.origin, got, tt.want)
+		}
+	}
+}
+
+func TestConfigClock(t *testing.T) {
+	getenv := func(string) string { return "" }
+
+	cfg, err := loadConfig([]string{"-start-time", "2024-01-15T09:00:00+05:30"}, getenv)
+	if err != nil {
+		t.Fatalf("loadConfig: %v", err)
+	}
+	start := time.Date(2024, 1, 15, 9, 0, 0, 0, time.FixedZone("IST", 5*3600+1800))
+	if now := cfg.clock().Now(); now.Before(start) || now.Sub(start) > time.Minute {
+		t.Errorf("clock at %v, want it started at %v", now, start)
+	}
+
+	cfg, err = loadConfig(nil, getenv)
+	if err != nil {
+		t.Fatalf("loadConfig: %v", err)
+	}
+	if _, ok := cfg.clock().(systemClock); !ok {
+		t.Errorf("default clock is %T, want systemClock", cfg.clock())
+	}
+
+	if _, err := loadConfig([]string{"-start-time", "9am"}, getenv); err == nil {
+		t.Error("loadConfig accepted a start time that is not RFC 3339")
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+)
+
+// offerSnapshot is the last state of each provider sent on a feed
+type offerSnapshot map[string]ServiceOffer // provider -> offer
+
+// Report whether a client would see a difference between two offers of the
+// same provider
+func offerChanged(previous, current ServiceOffer) bool {
+	return previous.Price != current.Price ||
+		previous.Offer != current.Offer ||
+		previous.DeliveryTime != current.DeliveryTime ||
+		previous.Duration != current.Duration ||
+		previous.Surge != current.Surge ||
+		previous.EffectivePrice != current.EffectivePrice ||
+		!reflect.DeepEqual(previous.Promotions, current.Promotions) ||
+		previous.Rank != current.Rank ||
+		previous.Score != current.Score ||
+		strings.Join(previous.Badges, ",") != strings.Join(current.Badges, ",")
+}
+
+// Compare offers with the last snapshot and return the providers that
+// changed or appeared, the providers that disappeared, and the new snapshot
+func diffOffers(last offerSnapshot, offers []ServiceOffer) ([]ServiceOffer, []string, offerSnapshot) {
+	next := make(offerSnapshot, len(offers))
+	changed := []ServiceOffer{}
+
+	for _, offer := range offers {
+		next[offer.ServiceName] = offer
+		if previous, ok := last[offer.ServiceName]; !ok || offerChanged(previous, offer) {
+			changed = append(changed, offer)
+		}
+	}
+
+	var removed []string
+	for name := range last {
+		if _, ok := next[name]; !ok {
+			removed = append(removed, name)
+		}
+	}
+	return changed, removed, next
+}
+
+// Take a snapshot of a full offer list
+func snapshotOffers(offers []ServiceOffer) offerSnapshot {
+	snapshot := make(offerSnapshot, len(offers))
+	for _, offer := range offers {
+		snapshot[offer.ServiceName] = offer
+	}
+	return snapshot
+}
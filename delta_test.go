@@ -0,0 +1,62 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDiffOffers(t *testing.T) {
+	base := ServiceOffer{
+		ServiceName:    "Zomato",
+		Price:          416.71,
+		Offer:          "20% off",
+		Promotions:     []Promotion{{Kind: PromotionPercent, Percent: 20}},
+		EffectivePrice: 349.34,
+		DeliveryTime:   35,
+		Rank:           1,
+		Score:          1,
+		Badges:         []string{BadgeCheapest},
+	}
+	last := snapshotOffers([]ServiceOffer{base, {ServiceName: "Swiggy", Price: 388.12}})
+
+	tests := []struct {
+		name   string
+		change func(o *ServiceOffer)
+	}{
+		{"price", func(o *ServiceOffer) { o.Price = 420 }},
+		{"offer", func(o *ServiceOffer) { o.Offer = "" }},
+		{"delivery time", func(o *ServiceOffer) { o.DeliveryTime = 40 }},
+		{"duration", func(o *ServiceOffer) { o.Duration = 25 }},
+		{"effective price", func(o *ServiceOffer) { o.EffectivePrice = 350 }},
+		{"promotions", func(o *ServiceOffer) { o.Promotions = []Promotion{{Kind: PromotionFreeDelivery}} }},
+		{"rank", func(o *ServiceOffer) { o.Rank = 2 }},
+		{"score", func(o *ServiceOffer) { o.Score = 1.05 }},
+		{"badges", func(o *ServiceOffer) { o.Badges = nil }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			current := base
+			tt.change(&current)
+			changed, removed, _ := diffOffers(last, []ServiceOffer{current})
+			if len(changed) != 1 || changed[0].ServiceName != "Zomato" {
+				t.Errorf("changed = %+v, want the Zomato offer", changed)
+			}
+			if !reflect.DeepEqual(removed, []string{"Swiggy"}) {
+				t.Errorf("removed = %v, want [Swiggy]", removed)
+			}
+		})
+	}
+
+	if changed, _, _ := diffOffers(last, []ServiceOffer{base}); len(changed) != 0 {
+		t.Errorf("unchanged offer reported as changed: %+v", changed)
+	}
+
+	// A provider not in the last snapshot is new, so it is sent
+	changed, _, next := diffOffers(last, []ServiceOffer{base, {ServiceName: "Swiggy", Price: 388.12}, {ServiceName: "Magicpin", Price: 401}})
+	if len(changed) != 1 || changed[0].ServiceName != "Magicpin" {
+		t.Errorf("changed = %+v, want the new Magicpin offer", changed)
+	}
+	if len(next) != 3 {
+		t.Errorf("next snapshot has %d offers, want 3", len(next))
+	}
+}
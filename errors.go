@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Header carrying the ID of a request, set on every response
+const requestIDHeader = "X-Request-ID"
+
+// Longest request ID accepted from a client
+const maxRequestIDLength = 64
+
+// HTTP status for each error code
+var errorStatus = map[string]int{
+	ErrCodeInvalidMessage:      http.StatusBadRequest,
+	ErrCodeUnsupportedVersion:  http.StatusBadRequest,
+	ErrCodeUnknownType:         http.StatusBadRequest,
+	ErrCodeValidation:          http.StatusBadRequest,
+	ErrCodeForbidden:           http.StatusForbidden,
+	ErrCodeNotFound:            http.StatusNotFound,
+	ErrCodeUnknownSubscription: http.StatusNotFound,
+	ErrCodeMethodNotAllowed:    http.StatusMethodNotAllowed,
+	ErrCodeSubscriptionLimit:   http.StatusTooManyRequests,
+	ErrCodeInternal:            http.StatusInternalServerError,
+	ErrCodeQuoteFailed:         http.StatusBadGateway,
+}
+
+// Status returns the HTTP status for the error's code
+func (e *ProtocolError) Status() int {
+	if status, ok := errorStatus[e.Code]; ok {
+		return status
+	}
+	return http.StatusInternalServerError
+}
+
+// ErrorResponse is the body of every failed /api request
+//
+//	{"error":{"code":"validation_failed","message":"compare request is invalid",
+//	 "details":{"fields":{"city":"is required"}},"requestId":"9f86d081884c7d65"}}
+type ErrorResponse struct {
+	Error *ProtocolError `json:"error"`
+}
+
+// Build a validation error from field problems
+func fieldErrors(message string, fields map[string]string) *ProtocolError {
+	return &ProtocolError{
+		Code:    ErrCodeValidation,
+		Message: message,
+		Details: &ErrorDetails{Fields: fields},
+	}
+}
+
+// Describe a quote that failed
+func quoteError(err error) *ProtocolError {
+	return &ProtocolError{
+		Code:    ErrCodeQuoteFailed,
+		Message: fmt.Sprintf("offers could not be quoted: %v", err),
+	}
+}
+
+// Write an error body with the status for its code, tagged with the ID of
+// the request
+func writeError(w http.ResponseWriter, r *http.Request, perr *ProtocolError) {
+	tagged := *perr
+	tagged.RequestID = requestIDFrom(r.Context())
+	writeJSON(w, r, tagged.Status(), ErrorResponse{Error: &tagged})
+}
+
+// Write a JSON body. It is encoded before anything is written, so a value
+// that cannot be encoded turns into an internal_error response.
+func writeJSON(w http.ResponseWriter, r *http.Request, status int, v interface{}) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		log.Printf("Error encoding response to %s: %v", r.URL.Path, err)
+		writeError(w, r, &ProtocolError{
+			Code:    ErrCodeInternal,
+			Message: "response could not be encoded",
+		})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(append(data, '\n'))
+}
+
+// Answer requests no /api route matched: method_not_allowed, listing the
+// allowed methods, when the path has a route for another method, otherwise
+// not_found. mux forgets a method mismatch when a later route shares the
+// path prefix, so the other methods are tried one by one.
+func apiFallback(api *mux.Router) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var allowed []string
+		for _, method := range []string{http.MethodGet, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete} {
+			if method == r.Method {
+				continue
+			}
+			probe := r.Clone(r.Context())
+			probe.Method = method
+			var match mux.RouteMatch
+			if api.Match(probe, &match) && match.MatchErr == nil {
+				allowed = append(allowed, method)
+			}
+		}
+
+		if len(allowed) > 0 {
+			w.Header().Set("Allow", strings.Join(allowed, ", "))
+			writeError(w, r, &ProtocolError{
+				Code:    ErrCodeMethodNotAllowed,
+				Message: fmt.Sprintf("%s is not allowed on %s, use %s", r.Method, r.URL.Path, strings.Join(allowed, " or ")),
+			})
+			return
+		}
+		writeError(w, r, &ProtocolError{
+			Code:    ErrCodeNotFound,
+			Message: "no API endpoint at " + r.URL.Path,
+		})
+	})
+}
+
+// Answer a failed WebSocket upgrade. The handshake is plain HTTP, so the
+// error is an HTTP error body.
+func writeUpgradeError(w http.ResponseWriter, r *http.Request, status int, reason error) {
+	code := ErrCodeInvalidMessage
+	switch status {
+	case http.StatusForbidden:
+		code = ErrCodeForbidden
+	case http.StatusMethodNotAllowed:
+		code = ErrCodeMethodNotAllowed
+	case http.StatusInternalServerError:
+		code = ErrCodeInternal
+	}
+	writeError(w, r, &ProtocolError{Code: code, Message: reason.Error()})
+}
+
+type requestIDKey struct{}
+
+// Give every request an ID: the client's X-Request-ID when it is usable,
+// otherwise a new one. The ID is echoed in the response header and in
+// error bodies and WebSocket error frames.
+func withRequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestIDHeader)
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+		w.Header().Set(requestIDHeader, id)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id)))
+	})
+}
+
+// ID of the request a context belongs to, if any
+func requestIDFrom(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// Accept short IDs made of letters, digits and a little punctuation, so they
+// are safe to log and echo
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '-', c == '_', c == '.', c == ':':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+func newRequestID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 16)
+	}
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+// Decode the error body of a failed request
+func decodeError(t *testing.T, w *httptest.ResponseRecorder) *ProtocolError {
+	t.Helper()
+
+	var response ErrorResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil || response.Error == nil {
+		t.Fatalf("body %q is not an error response: %v", w.Body, err)
+	}
+	if response.Error.Details == nil {
+		response.Error.Details = &ErrorDetails{}
+	}
+	return response.Error
+}
+
+func TestWriteErrorUsesCodeStatus(t *testing.T) {
+	w := httptest.NewRecorder()
+	writeError(w, httptest.NewRequest("GET", "/api/compare/taxi", nil), fieldErrors("compare request is invalid", map[string]string{"toState": "is required"}))
+
+	if w.Code != http.StatusBadRequest || w.Header().Get("Content-Type") != "application/json" {
+		t.Fatalf("status %d, content type %q", w.Code, w.Header().Get("Content-Type"))
+	}
+	perr := decodeError(t, w)
+	if perr.Code != ErrCodeValidation || perr.Details.Fields["toState"] != "is required" {
+		t.Errorf("error = %+v", perr)
+	}
+
+	if status := (&ProtocolError{Code: "no_such_code"}).Status(); status != http.StatusInternalServerError {
+		t.Errorf("unknown code status = %d, want 500", status)
+	}
+}
+
+func TestRequestIDs(t *testing.T) {
+	handler := withRequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, r, &ProtocolError{Code: ErrCodeNotFound, Message: "nothing here"})
+	}))
+
+	tests := []struct {
+		header string
+		kept   bool
+	}{
+		{"abc-123", true},
+		{"trace:7.1_a", true},
+		{"", false},
+		{"has spaces", false},
+		{strings.Repeat("a", maxRequestIDLength+1), false},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/api/nothing", nil)
+		if tt.header != "" {
+			r.Header.Set(requestIDHeader, tt.header)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+
+		id := w.Header().Get(requestIDHeader)
+		if tt.kept && id != tt.header || !tt.kept && (id == tt.header || !validRequestID(id)) {
+			t.Errorf("header %q gave request ID %q", tt.header, id)
+		}
+		if perr := decodeError(t, w); perr.RequestID != id {
+			t.Errorf("header %q: body request ID %q, want %q", tt.header, perr.RequestID, id)
+		}
+	}
+}
+
+func TestAPIFallback(t *testing.T) {
+	api := mux.NewRouter().PathPrefix("/api").Subrouter()
+	api.NotFoundHandler = apiFallback(api)
+	api.MethodNotAllowedHandler = api.NotFoundHandler
+	api.HandleFunc("/compare", compare).Methods("POST")
+	api.HandleFunc("/compare/taxi", compareTaxi).Methods("GET")
+
+	w := httptest.NewRecorder()
+	api.ServeHTTP(w, httptest.NewRequest("GET", "/api/compare", nil))
+	if w.Code != http.StatusMethodNotAllowed || w.Header().Get("Allow") != "POST" || decodeError(t, w).Code != ErrCodeMethodNotAllowed {
+		t.Errorf("wrong method: status %d, Allow %q: %s", w.Code, w.Header().Get("Allow"), w.Body)
+	}
+
+	w = httptest.NewRecorder()
+	api.ServeHTTP(w, httptest.NewRequest("GET", "/api/nothing", nil))
+	if w.Code != http.StatusNotFound || decodeError(t, w).Code != ErrCodeNotFound {
+		t.Errorf("unknown path: status %d: %s", w.Code, w.Body)
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+// PriceBreakdown itemizes an offer's Price. Its parts add up to Price.
+// Offers share breakdowns when they are copied, so a breakdown is never
+// changed once it is attached; it is replaced instead.
+type PriceBreakdown struct {
+	Item        float64 `json:"item"`
+	Delivery    float64 `json:"delivery,omitempty"`
+	PlatformFee float64 `json:"platformFee,omitempty"`
+	GST         float64 `json:"gst"`
+	Packaging   float64 `json:"packaging,omitempty"`
+	Surge       float64 `json:"surge,omitempty"`
+}
+
+// Total returns the sum of the parts
+func (b PriceBreakdown) Total() float64 {
+	return roundTotal(b.Item + b.Delivery + b.PlatformFee + b.GST + b.Packaging + b.Surge)
+}
+
+// FeeSchedule is what a provider adds to the item cost of an offer
+type FeeSchedule struct {
+	Delivery    float64
+	PlatformFee float64
+	Packaging   float64
+	// GST charged on the item and the fees
+	GSTRate float64
+}
+
+// Fixed fees never take more than this share of an offer before GST, so
+// that cheap items keep a positive item cost
+const maxFeeShare = 0.5
+
+// Fee schedules of the built-in providers
+var feeSchedules = map[string]FeeSchedule{
+	"Uber":    {PlatformFee: 25, GSTRate: 0.05},
+	"Ola":     {PlatformFee: 20, GSTRate: 0.05},
+	"Zomato":  {Delivery: 30, PlatformFee: 10, Packaging: 20, GSTRate: 0.05},
+	"Swiggy":  {Delivery: 25, PlatformFee: 12, Packaging: 20, GSTRate: 0.05},
+	"Zepto":   {Delivery: 25, PlatformFee: 4, GSTRate: 0.05},
+	"Blinkit": {Delivery: 30, PlatformFee: 2, GSTRate: 0.05},
+}
+
+// Schedule for providers without their own entry
+var defaultFeeSchedule = FeeSchedule{GSTRate: 0.05}
+
+func feeScheduleFor(provider string) FeeSchedule {
+	if schedule, ok := feeSchedules[provider]; ok {
+		return schedule
+	}
+	return defaultFeeSchedule
+}
+
+// Split a price without surge into item cost, fees and GST following the
+// provider's fee schedule. Rounding differences go to the item cost, so the
+// parts always add up to the price.
+func itemizePrice(provider string, price float64) *PriceBreakdown {
+	schedule := feeScheduleFor(provider)
+
+	beforeGST := price / (1 + schedule.GSTRate)
+	scale := 1.0
+	if fixed := schedule.Delivery + schedule.PlatformFee + schedule.Packaging; fixed > beforeGST*maxFeeShare {
+		scale = beforeGST * maxFeeShare / fixed
+	}
+
+	b := &PriceBreakdown{
+		Delivery:    roundTotal(schedule.Delivery * scale),
+		PlatformFee: roundTotal(schedule.PlatformFee * scale),
+		Packaging:   roundTotal(schedule.Packaging * scale),
+		GST:         roundTotal(price - beforeGST),
+	}
+	b.Item = roundTotal(price - b.Delivery - b.PlatformFee - b.Packaging - b.GST)
+	return b
+}
+
+// Attach a breakdown of the current price to an offer
+func itemizeOffer(offer *ServiceOffer) {
+	offer.Breakdown = itemizePrice(offer.ServiceName, offer.Price)
+}
+
+// Raise an offer's price by a surge multiplier, reporting the increase as
+// the surge part of its breakdown. Without surge only the multiplier is
+// recorded.
+func applySurge(offer *ServiceOffer, multiplier float64) {
+	offer.Surge = multiplier
+	if multiplier == 1 {
+		return
+	}
+
+	if offer.Breakdown == nil {
+		itemizeOffer(offer)
+	}
+	before := offer.Price
+	breakdown := *offer.Breakdown
+
+	offer.Price = roundTotal(before * multiplier)
+	breakdown.Surge = roundTotal(offer.Price - before)
+	offer.Breakdown = &breakdown
+}
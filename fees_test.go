@@ -0,0 +1,47 @@
+package main
+
+import "testing"
+
+func TestItemizePriceAddsUp(t *testing.T) {
+	for _, provider := range []string{"Uber", "Ola", "Zomato", "Swiggy", "Zepto", "Blinkit", "Other"} {
+		for _, price := range []float64{9.99, 42, 267.43, 1234.56} {
+			b := itemizePrice(provider, price)
+			if b.Total() != price {
+				t.Errorf("%s %v: parts %+v add up to %v", provider, price, b, b.Total())
+			}
+			if b.Item <= 0 {
+				t.Errorf("%s %v: item cost %v, want positive", provider, price, b.Item)
+			}
+		}
+	}
+}
+
+func TestItemizePriceScalesFees(t *testing.T) {
+	// Zomato's 60 in fixed fees would take most of a 50 order, so they are
+	// scaled down to half of it before GST
+	b := itemizePrice("Zomato", 52.5)
+	if b.GST != 2.5 || b.Delivery != 12.5 || b.PlatformFee != 4.17 || b.Packaging != 8.33 || b.Item != 25 {
+		t.Errorf("breakdown = %+v", b)
+	}
+
+	// Dearer orders pay the full schedule
+	if b := itemizePrice("Zomato", 267.43); b.Delivery != 30 || b.PlatformFee != 10 || b.Packaging != 20 || b.GST != 12.73 || b.Item != 194.7 {
+		t.Errorf("breakdown = %+v", b)
+	}
+}
+
+func TestApplySurge(t *testing.T) {
+	offer := ServiceOffer{ServiceName: "Uber", Price: 300}
+	applySurge(&offer, 1.15)
+	if offer.Surge != 1.15 || offer.Price != 345 || offer.Breakdown.Surge != 45 || offer.Breakdown.Total() != 345 {
+		t.Errorf("surged offer = %+v, breakdown %+v", offer, offer.Breakdown)
+	}
+
+	// Without surge the offer keeps its price and breakdown
+	breakdown := itemizePrice("Ola", 300)
+	offer = ServiceOffer{ServiceName: "Ola", Price: 300, Breakdown: breakdown}
+	applySurge(&offer, 1)
+	if offer.Surge != 1 || offer.Price != 300 || offer.Breakdown != breakdown {
+		t.Errorf("offer without surge = %+v", offer)
+	}
+}
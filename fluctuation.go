@@ -0,0 +1,219 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"time"
+)
+
+// Built-in fluctuation models
+const (
+	// FluctuationWalk is a random walk that stays within a band around the
+	// base price
+	FluctuationWalk = "walk"
+	// FluctuationMeanReverting keeps pulling prices back towards the base
+	// price (Ornstein-Uhlenbeck style)
+	FluctuationMeanReverting = "mean-reverting"
+	// FluctuationTimeOfDay follows a daily pattern with peak hours
+	FluctuationTimeOfDay = "time-of-day"
+	// FluctuationFrozen leaves prices unchanged
+	FluctuationFrozen = "frozen"
+)
+
+// Furthest a bounded walk may move from the base price
+const maxWalkDeviation = 0.25
+
+// Prices never drop below this fraction of the base price
+const minPriceFactor = 0.5
+
+// Catalog name under which the base prices are stored
+const fluctuationBasesCatalog = "fluctuation-bases"
+
+// FluctuationModel moves prices between updates. Prices are expressed as a
+// factor of the offer's base price, so rounding the published price never
+// feeds back into the next step.
+type FluctuationModel interface {
+	// Next returns the factor that follows the current one
+	Next(factor float64, now time.Time, random RandomSource) float64
+}
+
+// Create a built-in model for a category. percent scales the size of each
+// random step.
+func newFluctuationModel(name, category string, percent float64) (FluctuationModel, error) {
+	step := percent / 100
+
+	switch name {
+	case FluctuationWalk:
+		return boundedWalk{step: step, bound: maxWalkDeviation}, nil
+	case FluctuationMeanReverting:
+		return meanReverting{reversion: 0.25, volatility: step}, nil
+	case FluctuationTimeOfDay:
+		return timeOfDay{peaks: peakHours[category], amplitude: 0.2, reversion: 0.3, volatility: step / 2}, nil
+	case FluctuationFrozen:
+		return frozen{}, nil
+	}
+	return nil, fmt.Errorf("unknown fluctuation model %q", name)
+}
+
+// Centred uniform noise with standard deviation 1
+func noise(random RandomSource) float64 {
+	return (random.Float64()*2 - 1) * math.Sqrt(3)
+}
+
+// boundedWalk moves by up to step either way and reflects off the edges of
+// the band
+type boundedWalk struct {
+	step  float64
+	bound float64
+}
+
+func (m boundedWalk) Next(factor float64, now time.Time, random RandomSource) float64 {
+	factor += (random.Float64()*2 - 1) * m.step
+
+	upper, lower := 1+m.bound, 1-m.bound
+	if factor > upper {
+		factor = 2*upper - factor
+	}
+	if factor < lower {
+		factor = 2*lower - factor
+	}
+	return factor
+}
+
+// meanReverting closes part of the gap to the base price on every step and
+// adds noise, so prices wander but keep coming back
+type meanReverting struct {
+	reversion  float64
+	volatility float64
+}
+
+func (m meanReverting) Next(factor float64, now time.Time, random RandomSource) float64 {
+	factor += m.reversion*(1-factor) + m.volatility*noise(random)
+	return math.Max(factor, minPriceFactor)
+}
+
+// Peak hours of each category, in local time
+var peakHours = map[string][]float64{
+	CategoryTaxi:          {9, 19},    // commutes
+	CategoryRestaurant:    {13, 20.5}, // lunch and dinner
+	CategoryQuickCommerce: {8, 21},    // breakfast and late-night orders
+}
+
+// timeOfDay reverts towards a target that rises around the peak hours
+type timeOfDay struct {
+	peaks      []float64
+	amplitude  float64
+	reversion  float64
+	volatility float64
+}
+
+func (m timeOfDay) Next(factor float64, now time.Time, random RandomSource) float64 {
+	factor += m.reversion*(m.target(now)-factor) + m.volatility*noise(random)
+	return math.Max(factor, minPriceFactor)
+}
+
+// Factor the price settles at for a time of day: 1 outside peak hours and
+// up to 1+amplitude at a peak, over a couple of hours either side
+func (m timeOfDay) target(now time.Time) float64 {
+	hour := float64(now.Hour()) + float64(now.Minute())/60
+
+	peak := 0.0
+	for _, p := range m.peaks {
+		// Distance to the peak, wrapping around midnight
+		d := math.Abs(hour - p)
+		if d > 12 {
+			d = 24 - d
+		}
+		peak = math.Max(peak, math.Exp(-d*d/2))
+	}
+	return 1 + m.amplitude*peak
+}
+
+// frozen keeps prices where they are
+type frozen struct{}
+
+func (frozen) Next(factor float64, now time.Time, random RandomSource) float64 {
+	return factor
+}
+
+// priceFluctuator applies the model of each category to the stored offers.
+// It remembers the first price it saw for every offer as the base price and
+// keeps the base prices in storage next to the offers, so that a restart
+// carries on from the same base rather than from a moved price.
+// It is only used by the price update goroutine.
+type priceFluctuator struct {
+	models  map[string]FluctuationModel // category -> model
+	maxStep float64                     // largest change per update, as a fraction of the price
+	random  RandomSource
+	clock   Clock
+	storage Storage
+
+	// Base price and current factor by category, key and provider
+	bases   map[string]float64
+	factors map[string]float64
+}
+
+// Create a fluctuator that moves prices by at most percent per update,
+// starting from the base prices in storage
+func newPriceFluctuator(models map[string]FluctuationModel, percent float64, random RandomSource, clock Clock, storage Storage) (*priceFluctuator, error) {
+	f := &priceFluctuator{
+		models:  models,
+		maxStep: percent / 100,
+		random:  random,
+		clock:   clock,
+		storage: storage,
+		bases:   make(map[string]float64),
+		factors: make(map[string]float64),
+	}
+	if _, err := storage.LoadCatalog(fluctuationBasesCatalog, &f.bases); err != nil {
+		return nil, fmt.Errorf("loading base prices: %w", err)
+	}
+	return f, nil
+}
+
+// Move every stored price one step
+func (f *priceFluctuator) apply(store *OfferStore) {
+	now := f.clock.Now()
+	added := false
+
+	store.UpdateAll(func(category, key string, offers []ServiceOffer) []ServiceOffer {
+		model, ok := f.models[category]
+		if !ok {
+			return offers
+		}
+
+		for i := range offers {
+			id := category + "|" + key + "|" + offers[i].ServiceName
+			base, known := f.bases[id]
+			if !known {
+				base = offers[i].Price
+				f.bases[id] = base
+				added = true
+			}
+			current, ok := f.factors[id]
+			if !ok {
+				// A stored price may have moved before a restart
+				current = 1
+				if base > 0 {
+					current = offers[i].Price / base
+				}
+			}
+
+			// Models with noise or a moving target can overshoot the
+			// configured step, so every step is clamped to it
+			step := f.maxStep * current
+			factor := math.Max(current-step, math.Min(current+step, model.Next(current, now, f.random)))
+			f.factors[id] = factor
+			offers[i].Price = roundPrice(base * factor)
+			itemizeOffer(&offers[i])
+		}
+		return offers
+	})
+
+	if added {
+		if err := f.storage.SaveCatalog(fluctuationBasesCatalog, f.bases); err != nil {
+			log.Printf("Error saving base prices: %v", err)
+		}
+	}
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"math"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestTimeOfDayTarget(t *testing.T) {
+	model := timeOfDay{peaks: peakHours[CategoryTaxi], amplitude: 0.2}
+	at := func(hour int) time.Time {
+		return time.Date(2024, 1, 15, hour, 0, 0, 0, time.UTC)
+	}
+
+	if got := model.target(at(9)); math.Abs(got-1.2) > 1e-9 {
+		t.Errorf("target at the peak = %v, want 1.2", got)
+	}
+	if got := model.target(at(3)); math.Abs(got-1) > 0.001 {
+		t.Errorf("target at night = %v, want about 1", got)
+	}
+	if quiet, near := model.target(at(14)), model.target(at(18)); near <= quiet {
+		t.Errorf("target near the evening peak %v is not above midday %v", near, quiet)
+	}
+}
+
+func TestFluctuationModelsStayPositive(t *testing.T) {
+	now := time.Date(2024, 1, 15, 19, 0, 0, 0, time.UTC)
+	for _, name := range []string{FluctuationWalk, FluctuationMeanReverting, FluctuationTimeOfDay, FluctuationFrozen} {
+		model, err := newFluctuationModel(name, CategoryTaxi, 50)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		random := NewRandomSource(1)
+		factor := 1.0
+		for i := 0; i < 1000; i++ {
+			factor = model.Next(factor, now, random)
+			if factor < minPriceFactor {
+				t.Fatalf("%s: factor %v fell below %v", name, factor, minPriceFactor)
+			}
+		}
+	}
+}
+
+// Store two offers under the same key in every category
+func seedFluctuationOffers(t *testing.T, store *OfferStore) {
+	t.Helper()
+	for _, category := range []string{CategoryTaxi, CategoryRestaurant, CategoryQuickCommerce} {
+		offers := []ServiceOffer{{ServiceName: "A", Price: 250}, {ServiceName: "B", Price: 400}}
+		if err := store.Update(category, "key", offers); err != nil {
+			t.Fatalf("Update: %v", err)
+		}
+	}
+}
+
+func newTestFluctuator(t *testing.T, models map[string]FluctuationModel, percent float64, seed int64, clock Clock, storage Storage) *priceFluctuator {
+	t.Helper()
+	fluctuator, err := newPriceFluctuator(models, percent, NewRandomSource(seed), clock, storage)
+	if err != nil {
+		t.Fatalf("newPriceFluctuator: %v", err)
+	}
+	return fluctuator
+}
+
+// With the same seed and clock readings, every model moves prices the same
+// way run after run
+func TestPriceFluctuatorRepeatsForSeed(t *testing.T) {
+	models := defaultConfig().fluctuationModels()
+
+	run := func() []ServiceOffer {
+		storage := newMemoryStorage()
+		store := newTestStore(t, storage)
+		seedFluctuationOffers(t, store)
+
+		clock := newFakeClock()
+		fluctuator := newTestFluctuator(t, models, 5, 42, clock, storage)
+		for i := 0; i < 20; i++ {
+			fluctuator.apply(store)
+			clock.Advance(5 * time.Minute)
+		}
+
+		var prices []ServiceOffer
+		for _, category := range []string{CategoryTaxi, CategoryRestaurant, CategoryQuickCommerce} {
+			offers, _ := store.Get(category, "key")
+			prices = append(prices, offers...)
+		}
+		return prices
+	}
+
+	first, second := run(), run()
+	if !reflect.DeepEqual(first, second) {
+		t.Fatalf("prices differ for the same seed and times:\n%+v\n%+v", first, second)
+	}
+}
+
+// Mean-reverting noise and the time-of-day target can ask for bigger moves
+// than fluctuationPercent allows
+func TestPriceFluctuatorClampsSteps(t *testing.T) {
+	for _, name := range []string{FluctuationWalk, FluctuationMeanReverting, FluctuationTimeOfDay} {
+		t.Run(name, func(t *testing.T) {
+			model, _ := newFluctuationModel(name, CategoryTaxi, 5)
+			models := map[string]FluctuationModel{CategoryTaxi: model}
+			storage := newMemoryStorage()
+			store := newTestStore(t, storage)
+			seedFluctuationOffers(t, store)
+
+			// Start at night so that the morning peak pulls prices up hard
+			clock := newFakeClock()
+			clock.Advance(-6 * time.Hour)
+			fluctuator := newTestFluctuator(t, models, 2, 3, clock, storage)
+
+			previous, _ := store.Get(CategoryTaxi, "key")
+			for i := 0; i < 200; i++ {
+				fluctuator.apply(store)
+				clock.Advance(3 * time.Minute)
+
+				current, _ := store.Get(CategoryTaxi, "key")
+				for j := range current {
+					// Allow for rounding to whole paise
+					if change := math.Abs(current[j].Price - previous[j].Price); change > previous[j].Price*0.02+0.01 {
+						t.Fatalf("step %d moved %s from %v to %v, more than 2%%", i, current[j].ServiceName, previous[j].Price, current[j].Price)
+					}
+				}
+				previous = current
+			}
+		})
+	}
+}
+
+// After a restart the walk keeps its band around the first price seen, not
+// around the moved price that was stored
+func TestPriceFluctuatorKeepsBaseAcrossRestarts(t *testing.T) {
+	storage := newMemoryStorage()
+	models := map[string]FluctuationModel{CategoryTaxi: boundedWalk{step: 0.05, bound: maxWalkDeviation}}
+	store := newTestStore(t, storage)
+	seedFluctuationOffers(t, store)
+
+	for restart := 0; restart < 20; restart++ {
+		// A restart reloads the offers and base prices from storage
+		store = newTestStore(t, storage)
+		fluctuator := newTestFluctuator(t, models, 5, int64(restart), newFakeClock(), storage)
+		for i := 0; i < 10; i++ {
+			fluctuator.apply(store)
+		}
+		if base := fluctuator.bases[CategoryTaxi+"|key|A"]; base != 250 {
+			t.Fatalf("restart %d: base price %v, want 250", restart, base)
+		}
+	}
+
+	offers, _ := store.Get(CategoryTaxi, "key")
+	for _, offer := range offers {
+		base := map[string]float64{"A": 250, "B": 400}[offer.ServiceName]
+		if offer.Price < base*(1-maxWalkDeviation)-0.01 || offer.Price > base*(1+maxWalkDeviation)+0.01 {
+			t.Errorf("%s price %v left the band around %v", offer.ServiceName, offer.Price, base)
+		}
+	}
+}
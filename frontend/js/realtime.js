@@ -13,7 +13,7 @@ function connectWebSocket() {
 
     // Create WebSocket URL using the current location
     const protocol = window.location.protocol === "https:" ? "wss:" : "ws:";
-    const wsUrl = `${protocol}//localhost:5000/ws`;
+    const wsUrl = `${protocol}//${window.location.host}/ws`;
     
     // Create new WebSocket connection
     socket = new WebSocket(wsUrl);
@@ -85,6 +85,12 @@ function subscribeToRealTimeUpdates(request) {
 // Handle incoming real-time updates
 function handleRealTimeUpdate(data) {
     console.log("Received real-time update:", data);
+
+    // Error frames share their codes with the HTTP API errors
+    if (data.type === "error") {
+        console.error(`Real-time error ${data.error.code}: ${data.error.message}`, data.error);
+        return;
+    }
     
     // Display updated results based on category
     switch (data.category) {
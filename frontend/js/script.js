@@ -7,6 +7,56 @@ document.addEventListener('DOMContentLoaded', function() {
     let wsConnected = false;
     let activeSubscription = null;
 
+    // Offers currently shown for each subscription ID, and the seq of the
+    // update they came from. Updates other than full snapshots only carry
+    // the providers that changed.
+    const latestOffers = {};
+    const latestSeq = {};
+    const snapshotRequested = {};
+
+    // Apply an update to the offers shown for its subscription. A gap in seq
+    // means an update was missed, so the delta is dropped and a full
+    // snapshot requested instead; null is returned until it arrives.
+    function mergeOffers(data) {
+        const key = data.id || '';
+        const current = latestOffers[key];
+
+        if (data.full) {
+            latestOffers[key] = data.offers;
+            latestSeq[key] = data.seq;
+            delete snapshotRequested[key];
+            return data.offers;
+        }
+        if (!current || data.seq !== latestSeq[key] + 1) {
+            requestSnapshot(key);
+            return null;
+        }
+
+        const removed = new Set(data.removed || []);
+        const changed = new Map(data.offers.map(offer => [offer.ServiceName, offer]));
+        const merged = current
+            .filter(offer => !removed.has(offer.ServiceName))
+            .map(offer => {
+                const update = changed.get(offer.ServiceName);
+                changed.delete(offer.ServiceName);
+                return update || offer;
+            })
+            .concat(Array.from(changed.values()));
+
+        latestOffers[key] = merged;
+        latestSeq[key] = data.seq;
+        return merged;
+    }
+
+    // Ask for a full update of a subscription, once until it arrives
+    function requestSnapshot(id) {
+        if (snapshotRequested[id] || !socket || socket.readyState !== WebSocket.OPEN) {
+            return;
+        }
+        snapshotRequested[id] = true;
+        socket.send(JSON.stringify({ v: 1, type: 'snapshot', id: id }));
+    }
+
 
     function connectWebSocket() {
         if (socket !== null) {
@@ -14,7 +64,7 @@ document.addEventListener('DOMContentLoaded', function() {
         }
 
         const protocol = window.location.protocol === "https:" ? "wss:" : "ws:";
-        const wsUrl = `${protocol}//localhost:5000/ws`;
+        const wsUrl = `${protocol}//${window.location.host}/ws`;
 
         socket = new WebSocket(wsUrl);
 
@@ -33,17 +83,27 @@ document.addEventListener('DOMContentLoaded', function() {
                 const data = JSON.parse(event.data);
                 console.log('Real-time update received:', data);
 
+                // A failed snapshot request may be retried
+                if (data && data.type === 'error') {
+                    delete snapshotRequested[data.id || ''];
+                    return;
+                }
+
                 // Handle real-time price updates
-                if (data && data.category && data.offers) {
+                if (data && data.type === 'update' && data.offers) {
+                    const offers = mergeOffers(data);
+                    if (!offers) {
+                        return;
+                    }
                     switch (data.category) {
                         case 'taxi':
-                            displayTaxiResults(data.offers, data.route);
+                            displayTaxiResults(offers, data.route);
                             break;
                         case 'restaurant':
-                            displayRestaurantResults(data.offers, data.location.split(', ')[0], data.location);
+                            displayRestaurantResults(offers, data.location.split(', ')[0], data.location);
                             break;
                         case 'quickcommerce':
-                            displayQuickCommerceResults(data.offers, data.location.split(', ')[0], data.location);
+                            displayQuickCommerceResults(offers, data.location.split(', ')[0], data.location);
                             break;
                     }
 
@@ -118,6 +178,10 @@ document.addEventListener('DOMContentLoaded', function() {
     const taxiFromStateSelect = document.getElementById('taxi-from-state');
     const taxiToCountrySelect = document.getElementById('taxi-to-country');
     const taxiToStateSelect = document.getElementById('taxi-to-state');
+    const taxiFromCitySelect = document.getElementById('taxi-from-city');
+    const taxiFromAddressSelect = document.getElementById('taxi-from-address');
+    const taxiToCitySelect = document.getElementById('taxi-to-city');
+    const taxiToAddressSelect = document.getElementById('taxi-to-address');
     const compareTaxiBtn = document.getElementById('compare-taxi-btn');
 
     // Restaurant form elements
@@ -224,6 +288,11 @@ document.addEventListener('DOMContentLoaded', function() {
         errorMessage.classList.remove('hidden');
     }
 
+    // Message of an API error body ({"error": {"code", "message", ...}})
+    function apiErrorMessage(data, fallback) {
+        return (data && data.error && data.error.message) || fallback;
+    }
+
     // Initialize select dropdowns with options
     function populateSelect(selectElement, options, labelProperty, valueProperty) {
         // Clear existing options except first (placeholder)
@@ -335,6 +404,8 @@ document.addEventListener('DOMContentLoaded', function() {
             if (data) {
                 if (category === 'restaurant' && data.restaurants) {
                     populateSelect(targetElement, data.restaurants);
+                } else if (category === 'taxi' && data.addresses) {
+                    populateSelect(targetElement, data.addresses);
                 } else if (category === 'quickcommerce' && data.addresses) {
                     populateSelect(targetElement, data.addresses);
 
@@ -370,6 +441,36 @@ document.addEventListener('DOMContentLoaded', function() {
         loadStates('taxi', taxiToCountrySelect.value, taxiToStateSelect);
     });
 
+    // Optional city and address for each end of the ride
+    [
+        [taxiFromCountrySelect, taxiFromStateSelect, taxiFromCitySelect, taxiFromAddressSelect],
+        [taxiToCountrySelect, taxiToStateSelect, taxiToCitySelect, taxiToAddressSelect]
+    ].forEach(([countrySelect, stateSelect, citySelect, addressSelect]) => {
+        stateSelect.addEventListener('change', () => {
+            resetOptionalSelect(citySelect);
+            resetOptionalSelect(addressSelect);
+            if (countrySelect.value && stateSelect.value) {
+                loadCities('taxi', countrySelect.value, stateSelect.value, citySelect);
+            }
+        });
+
+        citySelect.addEventListener('change', () => {
+            resetOptionalSelect(addressSelect);
+            if (citySelect.value) {
+                loadFinalOptions('taxi', countrySelect.value, stateSelect.value, citySelect.value, addressSelect);
+            }
+        });
+    });
+
+    // Clear an optional select back to its "any" placeholder
+    function resetOptionalSelect(selectElement) {
+        while (selectElement.options.length > 1) {
+            selectElement.remove(1);
+        }
+        selectElement.value = '';
+        selectElement.disabled = true;
+    }
+
     // Enable the compare button when all selects are filled
     [taxiFromStateSelect, taxiToStateSelect].forEach(select => {
         select.addEventListener('change', () => {
@@ -469,25 +570,39 @@ document.addEventListener('DOMContentLoaded', function() {
         const toCountry = taxiToCountrySelect.value;
         const toState = taxiToStateSelect.value;
 
+        // Optional city and address for each end, sent only when chosen
+        const places = {};
+        [
+            ['fromCity', taxiFromCitySelect], ['fromAddress', taxiFromAddressSelect],
+            ['toCity', taxiToCitySelect], ['toAddress', taxiToAddressSelect]
+        ].forEach(([name, select]) => {
+            if (select.value) {
+                places[name] = select.value;
+            }
+        });
+        const describeEnd = (state, city, address) => address ? `${address}, ${city}` : (city || state);
+        const route = `${describeEnd(fromState, places.fromCity, places.fromAddress)} to ${describeEnd(toState, places.toCity, places.toAddress)}`;
+
         try {
             // Initial fetch using regular API
             const queryParams = new URLSearchParams({
                 fromCountry: fromCountry,
                 fromState: fromState,
                 toCountry: toCountry,
-                toState: toState
+                toState: toState,
+                ...places
             });
 
             const response = await fetch(`${API_BASE_URL}/compare/taxi?${queryParams}`);
             const data = await response.json();
 
             if (response.status >= 400 || data.error) {
-                showError(data.error || 'No taxi services found for this route.');
+                showError(apiErrorMessage(data, 'No taxi services found for this route.'));
                 return;
             }
 
             if (data && data.length > 0) {
-                displayTaxiResults(data, `${fromState} to ${toState}`);
+                displayTaxiResults(data, route);
 
                 // Subscribe to real-time updates
                 subscribeToRealTimeUpdates({
@@ -495,7 +610,8 @@ document.addEventListener('DOMContentLoaded', function() {
                     fromCountry: fromCountry,
                     fromState: fromState,
                     toCountry: toCountry,
-                    toState: toState
+                    toState: toState,
+                    ...places
                 });
             } else {
                 showError('No taxi services available for this route.');
@@ -529,7 +645,7 @@ document.addEventListener('DOMContentLoaded', function() {
             const data = await response.json();
 
             if (response.status >= 400 || data.error) {
-                showError(data.error || 'No delivery services found for this restaurant.');
+                showError(apiErrorMessage(data, 'No delivery services found for this restaurant.'));
                 return;
             }
 
@@ -582,7 +698,7 @@ document.addEventListener('DOMContentLoaded', function() {
             const data = await response.json();
 
             if (response.status >= 400 || data.error) {
-                showError(data.error || 'No quick commerce services found for this location.');
+                showError(apiErrorMessage(data, 'No quick commerce services found for this location.'));
                 return;
             }
 
@@ -623,6 +739,48 @@ document.addEventListener('DOMContentLoaded', function() {
 
     // Results display functions
 
+    // Price of an offer after its promotions
+    function effectivePriceOf(offer) {
+        return offer.effectivePrice || offer.Price;
+    }
+
+    function renderEffectivePrice(offer) {
+        const effective = effectivePriceOf(offer);
+        if (effective >= offer.Price) {
+            return '';
+        }
+        return `<div class="effective-price">₹${effective.toFixed(2)} after offer</div>`;
+    }
+
+    // Verdicts of the server's ranking
+    function renderBadges(badges) {
+        if (!badges || badges.length === 0) {
+            return '';
+        }
+        return `<div class="badges">${badges.map(badge => `<span class="badge">${badge}</span>`).join('')}</div>`;
+    }
+
+    // Itemized price of an offer, omitting parts that are zero
+    function renderBreakdown(breakdown) {
+        if (!breakdown) {
+            return '';
+        }
+
+        const parts = [
+            ['Item', breakdown.item],
+            ['Delivery', breakdown.delivery],
+            ['Platform fee', breakdown.platformFee],
+            ['Packaging', breakdown.packaging],
+            ['GST', breakdown.gst],
+            ['Surge', breakdown.surge]
+        ].filter(([, amount]) => amount);
+
+        return `
+                <ul class="breakdown">
+                    ${parts.map(([label, amount]) => `<li><span>${label}</span><span>₹${amount.toFixed(2)}</span></li>`).join('')}
+                </ul>`;
+    }
+
     // Taxi results
     function displayTaxiResults(offers, route) {
         results.classList.remove('hidden');
@@ -640,8 +798,8 @@ document.addEventListener('DOMContentLoaded', function() {
             resultsContainer.appendChild(routeInfo);
         }
 
-        // Sort offers by price
-        offers.sort((a, b) => a.Price - b.Price);
+        // Keep the server's ranking, falling back to price after promotions
+        offers.sort((a, b) => (a.rank || 0) - (b.rank || 0) || effectivePriceOf(a) - effectivePriceOf(b));
 
         // Find the best deal
         const bestDeal = offers[0];
@@ -660,7 +818,7 @@ document.addEventListener('DOMContentLoaded', function() {
             }
 
             // Apply best deal class
-            if (offer.Price === bestDeal.Price) {
+            if (effectivePriceOf(offer) === effectivePriceOf(bestDeal)) {
                 card.classList.add('best-deal');
             } else {
                 card.classList.remove('best-deal');
@@ -673,9 +831,13 @@ document.addEventListener('DOMContentLoaded', function() {
             // Create content
             card.innerHTML = `
                 <h4>${offer.ServiceName}</h4>
+                ${renderBadges(offer.badges)}
                 <div class="price${oldPrice !== null && oldPrice !== offer.Price ? ' price-changed' : ''}">₹${offer.Price.toFixed(2)}</div>
+                ${renderEffectivePrice(offer)}
                 <div class="offer"><i class="fas fa-tag"></i> ${offer.Offer}</div>
                 <div class="duration"><i class="fas fa-clock"></i> ${Math.floor(offer.Duration / 60)}h ${offer.Duration % 60}m</div>
+                ${offer.surge > 1 ? `<div class="surge"><i class="fas fa-bolt"></i> ${offer.surge.toFixed(2)}x surge</div>` : ''}
+                ${renderBreakdown(offer.breakdown)}
             `;
 
             // Add to container if new
@@ -716,8 +878,8 @@ document.addEventListener('DOMContentLoaded', function() {
             resultsContainer.appendChild(restaurantInfo);
         }
 
-        // Sort offers by price
-        offers.sort((a, b) => a.Price - b.Price);
+        // Keep the server's ranking, falling back to price after promotions
+        offers.sort((a, b) => (a.rank || 0) - (b.rank || 0) || effectivePriceOf(a) - effectivePriceOf(b));
 
         // Find the best deal
         const bestDeal = offers[0];
@@ -736,7 +898,7 @@ document.addEventListener('DOMContentLoaded', function() {
             }
 
             // Apply best deal class
-            if (offer.Price === bestDeal.Price) {
+            if (effectivePriceOf(offer) === effectivePriceOf(bestDeal)) {
                 card.classList.add('best-deal');
             } else {
                 card.classList.remove('best-deal');
@@ -749,9 +911,12 @@ document.addEventListener('DOMContentLoaded', function() {
             // Create content
             card.innerHTML = `
                 <h4>${offer.ServiceName}</h4>
+                ${renderBadges(offer.badges)}
                 <div class="price${oldPrice !== null && oldPrice !== offer.Price ? ' price-changed' : ''}">₹${offer.Price.toFixed(2)}</div>
+                ${renderEffectivePrice(offer)}
                 <div class="offer"><i class="fas fa-tag"></i> ${offer.Offer}</div>
                 <div class="delivery-time"><i class="fas fa-clock"></i> ${offer.DeliveryTime} minutes</div>
+                ${renderBreakdown(offer.breakdown)}
             `;
 
             // Add to container if new
@@ -801,8 +966,8 @@ document.addEventListener('DOMContentLoaded', function() {
             resultsContainer.appendChild(addressInfo);
         }
 
-        // Sort offers by price
-        offers.sort((a, b) => a.Price - b.Price);
+        // Keep the server's ranking, falling back to price after promotions
+        offers.sort((a, b) => (a.rank || 0) - (b.rank || 0) || effectivePriceOf(a) - effectivePriceOf(b));
 
         // Find the best deal
         const bestDeal = offers[0];
@@ -821,7 +986,7 @@ document.addEventListener('DOMContentLoaded', function() {
             }
 
             // Apply best deal class
-            if (offer.Price === bestDeal.Price) {
+            if (effectivePriceOf(offer) === effectivePriceOf(bestDeal)) {
                 card.classList.add('best-deal');
             } else {
                 card.classList.remove('best-deal');
@@ -834,9 +999,12 @@ document.addEventListener('DOMContentLoaded', function() {
             // Create content
             card.innerHTML = `
                 <h4>${offer.ServiceName}</h4>
+                ${renderBadges(offer.badges)}
                 <div class="price${oldPrice !== null && oldPrice !== offer.Price ? ' price-changed' : ''}">₹${offer.Price.toFixed(2)}</div>
+                ${renderEffectivePrice(offer)}
                 <div class="offer"><i class="fas fa-tag"></i> ${offer.Offer}</div>
                 <div class="delivery-time"><i class="fas fa-clock"></i> ${offer.DeliveryTime} minutes</div>
+                ${renderBreakdown(offer.breakdown)}
             `;
 
             // Add to container if new
@@ -0,0 +1,145 @@
+package main
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"sort"
+	"strings"
+)
+
+// Coordinates of every state and city in locationOptions. A state's
+// coordinates are those of its capital or main city.
+//
+//go:embed data/gazetteer.json
+var gazetteerData []byte
+
+// Mean radius of the Earth in kilometres
+const earthRadiusKm = 6371.0
+
+// Coordinates is a point in decimal degrees
+type Coordinates struct {
+	Lat float64 `json:"lat"`
+	Lon float64 `json:"lon"`
+}
+
+// Great-circle distance between two points in kilometres
+func haversineKm(a, b Coordinates) float64 {
+	toRad := func(deg float64) float64 { return deg * math.Pi / 180 }
+
+	dLat := toRad(b.Lat - a.Lat)
+	dLon := toRad(b.Lon - a.Lon)
+	h := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(toRad(a.Lat))*math.Cos(toRad(b.Lat))*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadiusKm * math.Asin(math.Sqrt(h))
+}
+
+// gazetteerFile is the layout of data/gazetteer.json
+type gazetteerFile struct {
+	Countries []struct {
+		Name   string `json:"name"`
+		States []struct {
+			Name string `json:"name"`
+			Coordinates
+			Cities []struct {
+				Name string `json:"name"`
+				Coordinates
+			} `json:"cities"`
+		} `json:"states"`
+	} `json:"countries"`
+}
+
+// Gazetteer looks up coordinates by case-insensitive place name
+type Gazetteer struct {
+	states map[string]Coordinates // "country:state"
+	cities map[string]Coordinates // "country:state:city"
+}
+
+// Parse gazetteer data in the data/gazetteer.json layout
+func loadGazetteer(data []byte) (*Gazetteer, error) {
+	var file gazetteerFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("parsing gazetteer: %w", err)
+	}
+
+	g := &Gazetteer{
+		states: make(map[string]Coordinates),
+		cities: make(map[string]Coordinates),
+	}
+	for _, country := range file.Countries {
+		for _, state := range country.States {
+			g.states[placeKey(country.Name, state.Name)] = state.Coordinates
+			for _, city := range state.Cities {
+				g.cities[placeKey(country.Name, state.Name, city.Name)] = city.Coordinates
+			}
+		}
+	}
+	return g, nil
+}
+
+func placeKey(names ...string) string {
+	return strings.ToLower(strings.Join(names, ":"))
+}
+
+// State returns the coordinates of a state
+func (g *Gazetteer) State(country, state string) (Coordinates, bool) {
+	c, ok := g.states[placeKey(country, state)]
+	return c, ok
+}
+
+// City returns the coordinates of a city
+func (g *Gazetteer) City(country, state, city string) (Coordinates, bool) {
+	c, ok := g.cities[placeKey(country, state, city)]
+	return c, ok
+}
+
+// Addresses in the catalog have no surveyed coordinates, so each one is
+// placed at a fixed spot between 1.5 and 9 km from its city centre, derived
+// from its key
+func addressCoordinates(city Coordinates, key string) Coordinates {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	sum := h.Sum32()
+
+	bearing := float64(sum%360) * math.Pi / 180
+	distance := 1.5 + float64(sum/360%76)/10
+
+	const kmPerDegree = 111.32
+	return Coordinates{
+		Lat: city.Lat + distance*math.Cos(bearing)/kmPerDegree,
+		Lon: city.Lon + distance*math.Sin(bearing)/(kmPerDegree*math.Cos(city.Lat*math.Pi/180)),
+	}
+}
+
+// Check that every state and city offered to users has coordinates
+func (g *Gazetteer) checkCoverage(options map[string]interface{}) error {
+	var missing []string
+
+	for country, states := range options["states"].(map[string][]string) {
+		for _, state := range states {
+			if _, ok := g.State(country, state); !ok {
+				missing = append(missing, state)
+			}
+		}
+	}
+	for country, states := range options["cities"].(map[string]map[string][]string) {
+		for state, cities := range states {
+			for _, city := range cities {
+				if _, ok := g.City(country, state, city); !ok {
+					missing = append(missing, city+", "+state)
+				}
+			}
+		}
+	}
+
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return fmt.Errorf("gazetteer has no coordinates for %s", strings.Join(missing, "; "))
+	}
+	return nil
+}
+
+// gazetteer holds the bundled coordinates. It is loaded in main.
+var gazetteer *Gazetteer
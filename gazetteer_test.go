@@ -0,0 +1,210 @@
+package main
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+// Load the bundled gazetteer for the length of a test
+func useBundledGazetteer(t *testing.T) {
+	t.Helper()
+
+	loaded, err := loadGazetteer(gazetteerData)
+	if err != nil {
+		t.Fatalf("loadGazetteer: %v", err)
+	}
+	old := gazetteer
+	t.Cleanup(func() { gazetteer = old })
+	gazetteer = loaded
+}
+
+func TestHaversineKm(t *testing.T) {
+	delhi := Coordinates{Lat: 28.61, Lon: 77.21}
+	mumbai := Coordinates{Lat: 19.08, Lon: 72.88}
+
+	if d := haversineKm(delhi, mumbai); d < 1140 || d > 1160 {
+		t.Errorf("Delhi to Mumbai = %.0f km, want about 1150", d)
+	}
+	if d, back := haversineKm(delhi, mumbai), haversineKm(mumbai, delhi); math.Abs(d-back) > 1e-9 {
+		t.Errorf("distance depends on direction: %v and %v", d, back)
+	}
+	if d := haversineKm(delhi, delhi); d != 0 {
+		t.Errorf("distance to the same point = %v", d)
+	}
+}
+
+func TestGazetteerLookup(t *testing.T) {
+	useBundledGazetteer(t)
+
+	if c, ok := gazetteer.State("india", "DELHI"); !ok || c != (Coordinates{Lat: 28.61, Lon: 77.21}) {
+		t.Errorf("State(india, DELHI) = %v, %v", c, ok)
+	}
+	if c, ok := gazetteer.City("India", "Maharashtra", "pune"); !ok || c != (Coordinates{Lat: 18.52, Lon: 73.86}) {
+		t.Errorf("City(Pune) = %v, %v", c, ok)
+	}
+	if _, ok := gazetteer.State("India", "Atlantis"); ok {
+		t.Error("found an unknown state")
+	}
+	if _, ok := gazetteer.City("India", "Delhi", "Pune"); ok {
+		t.Error("found a city in the wrong state")
+	}
+
+	if _, err := loadGazetteer([]byte("{")); err == nil {
+		t.Error("loadGazetteer accepted malformed data")
+	}
+}
+
+func TestGazetteerCoverage(t *testing.T) {
+	useBundledGazetteer(t)
+
+	if err := gazetteer.checkCoverage(locationOptions); err != nil {
+		t.Errorf("bundled gazetteer: %v", err)
+	}
+
+	options := map[string]interface{}{
+		"states": map[string][]string{"India": {"Delhi", "Atlantis"}},
+		"cities": map[string]map[string][]string{"India": {"Delhi": {"New Delhi", "Lemuria"}}},
+	}
+	err := gazetteer.checkCoverage(options)
+	if err == nil || !strings.Contains(err.Error(), "Atlantis; Lemuria, Delhi") {
+		t.Errorf("checkCoverage = %v, want Atlantis and Lemuria reported", err)
+	}
+}
+
+func TestTaxiTrip(t *testing.T) {
+	useBundledGazetteer(t)
+
+	request := testTaxiRequest()
+	distance, minutes, err := taxiTrip(request)
+	if err != nil {
+		t.Fatalf("no trip between Punjab and Delhi: %v", err)
+	}
+	from, _ := gazetteer.State("India", "Punjab")
+	to, _ := gazetteer.State("India", "Delhi")
+	if want := haversineKm(from, to) * taxiRoadFactor; math.Abs(distance-want) > 1e-9 {
+		t.Errorf("distance = %v, want %v", distance, want)
+	}
+	if want := taxiPickupMinutes + int(math.Round(distance/taxiAverageSpeed*60)); minutes != want {
+		t.Errorf("minutes = %d, want %d", minutes, want)
+	}
+
+	// Trips within a place are charged the minimum distance
+	request.FromState = "Delhi"
+	if distance, _, _ := taxiTrip(request); distance != taxiMinimumKm {
+		t.Errorf("distance within Delhi = %v, want %v", distance, taxiMinimumKm)
+	}
+
+	request.ToState = "Atlantis"
+	if _, _, err := taxiTrip(request); err == nil || !strings.Contains(err.Error(), "Atlantis") {
+		t.Errorf("trip to an unknown state: err = %v, want Atlantis reported", err)
+	}
+	if _, err := quoteUberTaxi(request, NewRandomSource(1)); err == nil {
+		t.Error("trip to an unknown state was quoted")
+	}
+}
+
+func TestTaxiQuotesFollowDistance(t *testing.T) {
+	useBundledGazetteer(t)
+	random := NewRandomSource(1)
+
+	near, far := testTaxiRequest(), testTaxiRequest()
+	far.ToCountry, far.ToState = "India", "Kerala"
+	n, _ := quoteUberTaxi(near, random)
+	f, _ := quoteUberTaxi(far, random)
+	if n.Price >= f.Price || n.Duration >= f.Duration {
+		t.Errorf("Punjab to Delhi (%v, %d min) is not cheaper and shorter than to Kerala (%v, %d min)", n.Price, n.Duration, f.Price, f.Duration)
+	}
+
+	// Ola is 30 minutes faster, but not faster than reaching the pickup
+	_, minutes, _ := taxiTrip(far)
+	if got, _ := quoteOlaTaxi(far, random); got.Duration != minutes-30 {
+		t.Errorf("Ola duration to Kerala = %d, want %d", got.Duration, minutes-30)
+	}
+	local := testTaxiRequest()
+	local.FromState = "Delhi"
+	if got, _ := quoteOlaTaxi(local, random); got.Duration != taxiPickupMinutes {
+		t.Errorf("Ola duration within Delhi = %d, want %d", got.Duration, taxiPickupMinutes)
+	}
+}
+
+// Set a generated catalog of locationOptions for the length of a test
+func useTestCatalog(t *testing.T, name string, catalog map[string]map[string][]string) {
+	t.Helper()
+
+	old, existed := locationOptions[name]
+	t.Cleanup(func() {
+		if existed {
+			locationOptions[name] = old
+		} else {
+			delete(locationOptions, name)
+		}
+	})
+	locationOptions[name] = catalog
+}
+
+func TestTaxiEndpoint(t *testing.T) {
+	useBundledGazetteer(t)
+	useTestCatalog(t, "addresses", map[string]map[string][]string{
+		"Delhi": {"New Delhi": {"Airport", "Connaught Place"}},
+	})
+
+	state, _ := gazetteer.State("India", "Delhi")
+	city, _ := gazetteer.City("India", "Delhi", "Rohini")
+	newDelhi, _ := gazetteer.City("India", "Delhi", "New Delhi")
+
+	tests := []struct {
+		name          string
+		city, address string
+		want          Coordinates
+	}{
+		{"state", "", "", state},
+		{"city", "rohini", "", city},
+		{"unknown city", "atlantis", "", state},
+		{"address outside the catalog", "new delhi", "nowhere", newDelhi},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got, ok := taxiEndpoint("india", "delhi", tt.city, tt.address); !ok || got != tt.want {
+				t.Errorf("taxiEndpoint = %v, %v, want %v", got, ok, tt.want)
+			}
+		})
+	}
+
+	airport, ok := taxiEndpoint("india", "delhi", "new delhi", "airport")
+	if !ok {
+		t.Fatal("no coordinates for a catalog address")
+	}
+	if d := haversineKm(newDelhi, airport); d < 1.4 || d > 9.2 {
+		t.Errorf("airport is %.2f km from the city centre, want 1.5 to 9", d)
+	}
+	if again, _ := taxiEndpoint("India", "Delhi", "New Delhi", "Airport"); again != airport {
+		t.Errorf("address moved between lookups: %v and %v", airport, again)
+	}
+	if other, _ := taxiEndpoint("india", "delhi", "new delhi", "connaught place"); other == airport {
+		t.Error("two addresses share coordinates")
+	}
+
+	if _, ok := taxiEndpoint("india", "atlantis", "", ""); ok {
+		t.Error("found an unknown state")
+	}
+}
+
+func TestTaxiRouteKeysAndNames(t *testing.T) {
+	state := testTaxiRequest()
+	city := state
+	city.FromCity, city.FromAddress = "new delhi", "airport"
+
+	if offerKey(state) == offerKey(city) {
+		t.Errorf("state and address routes share the key %q", offerKey(state))
+	}
+	if got := describeTaxiEndpoint(city.FromState, city.FromCity, city.FromAddress); got != "airport, new delhi" {
+		t.Errorf("describeTaxiEndpoint = %q", got)
+	}
+	if got := describeTaxiEndpoint("delhi", "rohini", ""); got != "rohini" {
+		t.Errorf("describeTaxiEndpoint = %q", got)
+	}
+	if got := describeTaxiEndpoint("delhi", "", ""); got != "delhi" {
+		t.Errorf("describeTaxiEndpoint = %q", got)
+	}
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Maximum number of points kept per category key and provider
+const defaultHistoryLimit = 10000
+
+// PricePoint is one recorded price for a provider
+type PricePoint struct {
+	Timestamp int64   `json:"timestamp"`
+	Price     float64 `json:"price"`
+}
+
+// HistorySeries is the time series of one provider for a category key
+type HistorySeries struct {
+	Provider string       `json:"provider"`
+	Points   []PricePoint `json:"points"`
+}
+
+// HistoryResponse is returned by /api/history
+type HistoryResponse struct {
+	Category string          `json:"category"`
+	Key      string          `json:"key"`
+	From     int64           `json:"from"`
+	To       int64           `json:"to"`
+	Series   []HistorySeries `json:"series"`
+}
+
+type historyKey struct {
+	category string
+	key      string
+}
+
+// PriceHistory records every price change per category key and provider.
+// Each series keeps at most limit points; the oldest points are dropped first.
+type PriceHistory struct {
+	mu     sync.RWMutex
+	series map[historyKey]map[string][]PricePoint // provider -> points
+	limit  int
+}
+
+// NewPriceHistory creates an empty history
+func NewPriceHistory(limit int) *PriceHistory {
+	if limit <= 0 {
+		limit = defaultHistoryLimit
+	}
+	return &PriceHistory{
+		series: make(map[historyKey]map[string][]PricePoint),
+		limit:  limit,
+	}
+}
+
+// Record appends a point for every offer whose price differs from the last
+// recorded price of its provider
+func (h *PriceHistory) Record(record OfferRecord, at time.Time) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	hk := historyKey{category: record.Category, key: record.Key}
+	byProvider := h.series[hk]
+	if byProvider == nil {
+		byProvider = make(map[string][]PricePoint)
+		h.series[hk] = byProvider
+	}
+
+	for _, offer := range record.Offers {
+		points := byProvider[offer.ServiceName]
+		if n := len(points); n > 0 && points[n-1].Price == offer.Price {
+			continue
+		}
+
+		points = append(points, PricePoint{Timestamp: at.Unix(), Price: offer.Price})
+		if len(points) > h.limit {
+			points = append(points[:0:0], points[len(points)-h.limit:]...)
+		}
+		byProvider[offer.ServiceName] = points
+	}
+}
+
+// Query returns the points recorded for a category key between from and to
+// (inclusive), optionally limited to one provider. Series are sorted by
+// provider name.
+func (h *PriceHistory) Query(category, key, provider string, from, to time.Time) []HistorySeries {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	result := []HistorySeries{}
+	for name, points := range h.series[historyKey{category: category, key: key}] {
+		if provider != "" && !strings.EqualFold(name, provider) {
+			continue
+		}
+
+		// Points are appended in time order, so the range can be found by search
+		start := sort.Search(len(points), func(i int) bool { return points[i].Timestamp >= from.Unix() })
+		end := sort.Search(len(points), func(i int) bool { return points[i].Timestamp > to.Unix() })
+
+		selected := make([]PricePoint, end-start)
+		copy(selected, points[start:end])
+		result = append(result, HistorySeries{Provider: name, Points: selected})
+	}
+
+	sort.Slice(result, func(i, j int) bool { return result[i].Provider < result[j].Provider })
+	return result
+}
+
+// priceHistory records the offers written to offerStore
+var priceHistory = NewPriceHistory(defaultHistoryLimit)
+
+// Parse a time query parameter given as unix seconds or RFC 3339
+func parseHistoryTime(value string, fallback time.Time) (time.Time, error) {
+	if value == "" {
+		return fallback, nil
+	}
+	if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Unix(seconds, 0), nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time %q: use unix seconds or RFC 3339", value)
+	}
+	return t, nil
+}
+
+// Get the price history for the same parameters as the compare endpoints,
+// plus an optional time range (from, to) and provider
+func getHistory(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	category := query.Get("category")
+	request := requestFromQuery(category, r)
+	fields := requestProblems(request)
+
+	now := clock.Now()
+	to, err := parseHistoryTime(query.Get("to"), now)
+	if err != nil {
+		fields["to"] = err.Error()
+		to = now
+	}
+	// Default to the last hour
+	from, err := parseHistoryTime(query.Get("from"), to.Add(-time.Hour))
+	if err != nil {
+		fields["from"] = err.Error()
+	} else if from.After(to) {
+		fields["from"] = "must not be after to"
+	}
+
+	if len(fields) > 0 {
+		writeError(w, r, fieldErrors("history request is invalid", fields))
+		return
+	}
+	if perr := checkCatalog(request); perr != nil {
+		writeError(w, r, perr)
+		return
+	}
+
+	key := offerKey(request)
+	writeJSON(w, r, http.StatusOK, HistoryResponse{
+		Category: category,
+		Key:      key,
+		From:     from.Unix(),
+		To:       to.Unix(),
+		Series:   priceHistory.Query(category, key, query.Get("provider"), from, to),
+	})
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPriceHistoryRecordsChanges(t *testing.T) {
+	history := NewPriceHistory(0)
+	start := time.Unix(1700000000, 0)
+	prices := []float64{100, 100, 110, 110, 105}
+	for i, price := range prices {
+		history.Record(OfferRecord{
+			Category: CategoryTaxi,
+			Key:      "a",
+			Offers:   []ServiceOffer{{ServiceName: "Uber", Price: price}, {ServiceName: "Ola", Price: 90}},
+		}, start.Add(time.Duration(i)*time.Minute))
+	}
+
+	got := history.Query(CategoryTaxi, "a", "", start, start.Add(time.Hour))
+	want := []HistorySeries{
+		{Provider: "Ola", Points: []PricePoint{{Timestamp: start.Unix(), Price: 90}}},
+		{Provider: "Uber", Points: []PricePoint{
+			{Timestamp: start.Unix(), Price: 100},
+			{Timestamp: start.Add(2 * time.Minute).Unix(), Price: 110},
+			{Timestamp: start.Add(4 * time.Minute).Unix(), Price: 105},
+		}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Query = %+v, want %+v", got, want)
+	}
+}
+
+func TestPriceHistoryQuery(t *testing.T) {
+	history := NewPriceHistory(3)
+	start := time.Unix(1700000000, 0)
+	for i := 0; i < 5; i++ {
+		history.Record(OfferRecord{
+			Category: CategoryRestaurant,
+			Key:      "a",
+			Offers:   []ServiceOffer{{ServiceName: "Zomato", Price: float64(200 + i)}, {ServiceName: "Swiggy", Price: float64(300 + i)}},
+		}, start.Add(time.Duration(i)*time.Minute))
+	}
+
+	// Only the last 3 points are kept
+	if got := history.Query(CategoryRestaurant, "a", "zomato", start, start.Add(time.Hour)); len(got) != 1 || len(got[0].Points) != 3 || got[0].Points[0].Price != 202 {
+		t.Errorf("Query after the limit = %+v", got)
+	}
+	// The range is inclusive
+	got := history.Query(CategoryRestaurant, "a", "Swiggy", start.Add(3*time.Minute), start.Add(4*time.Minute))
+	if len(got) != 1 || len(got[0].Points) != 2 {
+		t.Errorf("Query of a range = %+v", got)
+	}
+	if got := history.Query(CategoryRestaurant, "b", "", start, start.Add(time.Hour)); len(got) != 0 {
+		t.Errorf("Query of an unknown key = %+v", got)
+	}
+}
+
+func TestParseHistoryTime(t *testing.T) {
+	fallback := time.Unix(1, 0)
+	tests := []struct {
+		value   string
+		want    time.Time
+		wantErr bool
+	}{
+		{"", fallback, false},
+		{"1700000000", time.Unix(1700000000, 0), false},
+		{"2024-01-15T09:00:00Z", time.Date(2024, 1, 15, 9, 0, 0, 0, time.UTC), false},
+		{"yesterday", time.Time{}, true},
+	}
+	for _, tt := range tests {
+		got, err := parseHistoryTime(tt.value, fallback)
+		if (err != nil) != tt.wantErr || !got.Equal(tt.want) {
+			t.Errorf("parseHistoryTime(%q) = %v, %v", tt.value, got, err)
+		}
+	}
+}
+
+func TestOfferStoreNotifiesWatchers(t *testing.T) {
+	store := newTestStore(t, newMemoryStorage())
+	var seen []OfferRecord
+	store.Watch(func(records []OfferRecord) { seen = append(seen, records...) })
+
+	store.Update(CategoryTaxi, "a", []ServiceOffer{{ServiceName: "Uber", Price: 100}})
+	store.UpdateAll(func(category, key string, offers []ServiceOffer) []ServiceOffer { return offers })
+	if len(seen) != 2 || seen[1].Key != "a" {
+		t.Errorf("watchers saw %+v", seen)
+	}
+}
+
+func TestGetHistoryValidatesLikeCompare(t *testing.T) {
+	tests := []struct {
+		query string
+		want  map[string]string
+	}{
+		{"", map[string]string{"category": "is required"}},
+		{"category=boats", map[string]string{"category": "unknown category"}},
+		{"category=taxi&fromState=punjab", map[string]string{"fromCountry": "is required", "toCountry": "is required", "toState": "is required"}},
+		{"category=restaurant&country=india&state=delhi&city=new delhi&restaurant=kfc&timeWeight=2", map[string]string{"timeWeight": "must be"}},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		getHistory(w, httptest.NewRequest("GET", "/api/history?"+strings.ReplaceAll(tt.query, " ", "%20"), nil))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%q: status %d, want 400", tt.query, w.Code)
+			continue
+		}
+		fields := decodeError(t, w).Details.Fields
+		for field, want := range tt.want {
+			if !strings.Contains(fields[field], want) {
+				t.Errorf("%q: %s = %q, want %q", tt.query, field, fields[field], want)
+			}
+		}
+	}
+
+	w := httptest.NewRecorder()
+	getHistory(w, httptest.NewRequest("GET", "/api/history?category=taxi&fromCountry=india&fromState=punjab&toCountry=india&toState=delhi", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("valid request: status %d: %s", w.Code, w.Body)
+	}
+}
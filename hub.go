@@ -0,0 +1,608 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// What the hub does when a client's outbound queue is full
+const (
+	// SlowConsumerDrop discards the oldest queued frame to make room
+	SlowConsumerDrop = "drop"
+	// SlowConsumerDisconnect closes the connection
+	SlowConsumerDisconnect = "disconnect"
+)
+
+// Maximum number of concurrent subscriptions on one WebSocket connection
+const maxSubscriptionsPerConn = 32
+
+// Maximum size of a client frame
+const maxClientMessageSize = 64 * 1024
+
+// Time a peer has to answer a close frame before the connection is dropped
+const closeGracePeriod = time.Second
+
+// HubOptions tunes the per-connection writers. Zero values use the defaults.
+type HubOptions struct {
+	// Number of frames queued per connection before the slow consumer
+	// policy applies
+	SendQueueSize int
+	// Time allowed to write one frame
+	WriteWait time.Duration
+	// Time allowed between pongs before a connection is considered dead.
+	// Pings are sent at 90% of this interval.
+	PongWait time.Duration
+	// SlowConsumerDrop or SlowConsumerDisconnect
+	SlowConsumerPolicy string
+	// Time source for frame timestamps
+	Clock Clock
+}
+
+func (o HubOptions) withDefaults() HubOptions {
+	if o.SendQueueSize <= 0 {
+		o.SendQueueSize = 64
+	}
+	if o.WriteWait <= 0 {
+		o.WriteWait = 10 * time.Second
+	}
+	if o.PongWait <= 0 {
+		o.PongWait = 60 * time.Second
+	}
+	if o.SlowConsumerPolicy == "" {
+		o.SlowConsumerPolicy = SlowConsumerDrop
+	}
+	if o.Clock == nil {
+		o.Clock = systemClock{}
+	}
+	return o
+}
+
+// Hub tracks the WebSocket clients and fans price updates out to them. Every
+// client has its own writer goroutine and bounded send queue, so a slow or
+// stalled connection never delays the others.
+type Hub struct {
+	opts HubOptions
+
+	mu      sync.Mutex
+	clients map[*Client]bool
+
+	// Set by Close; new connections are closed right away
+	closing     bool
+	closeReason string
+	// Running WebSocket connections, for Wait
+	wg sync.WaitGroup
+
+	// Number of live subscriptions and streams per stream key. It has its
+	// own lock because it is read while quoting, when h.mu or a client's
+	// lock may already be held.
+	watchMu  sync.Mutex
+	watchers map[string]int
+
+	// Server-Sent Event streams by stream key, with their recent events
+	streams     map[string]map[*sseStream]bool
+	streamLogs  map[string]*streamLog
+	nextEventID uint64
+}
+
+// NewHub creates a hub with no clients
+func NewHub(opts HubOptions) *Hub {
+	return &Hub{
+		opts:       opts.withDefaults(),
+		clients:    make(map[*Client]bool),
+		streams:    make(map[string]map[*sseStream]bool),
+		streamLogs: make(map[string]*streamLog),
+		watchers:   make(map[string]int),
+	}
+}
+
+// Subscribers returns the number of live WebSocket subscriptions and event
+// streams for a stream key
+func (h *Hub) Subscribers(key string) int {
+	h.watchMu.Lock()
+	defer h.watchMu.Unlock()
+	return h.watchers[key]
+}
+
+// Count a subscription or stream for a key in or out
+func (h *Hub) watch(key string, delta int) {
+	h.watchMu.Lock()
+	defer h.watchMu.Unlock()
+
+	h.watchers[key] += delta
+	if h.watchers[key] <= 0 {
+		delete(h.watchers, key)
+	}
+}
+
+// ClientSubscription is one subscription held by a client
+type ClientSubscription struct {
+	request RealTimeRequest
+	alerts  *alertState
+
+	// Sequence number of the last update and the offers it left the client
+	// with, used to send only what changed
+	seq  uint64
+	sent offerSnapshot
+
+	// Set while quoting fails, so that the error is sent once rather than
+	// on every broadcast
+	failing bool
+}
+
+// Client is a WebSocket connection registered with the hub
+type Client struct {
+	hub  *Hub
+	conn *websocket.Conn
+	send chan []byte
+
+	// ID of the upgrade request, sent in every error frame
+	requestID string
+
+	// Canceled when the connection closes
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	// mu guards subscriptions and their alert state, and orders the frames a
+	// subscription produces
+	mu            sync.Mutex
+	subscriptions map[string]*ClientSubscription // subscription ID -> subscription
+
+	closeOnce   sync.Once
+	done        chan struct{}
+	closeCode   int
+	closeReason string
+
+	// Closed when the reader and the writer have returned
+	readDone  chan struct{}
+	writeDone chan struct{}
+
+	// Frames dropped by the slow consumer policy, guarded by mu
+	dropped int
+}
+
+// ServeWS upgrades an HTTP request and runs the connection until it closes
+func (h *Hub) ServeWS(w http.ResponseWriter, r *http.Request) {
+	// Upgrade HTTP connection to WebSocket. The hijacked connection writes
+	// its own response headers, so the request ID is passed on.
+	requestID := requestIDFrom(r.Context())
+	conn, err := upgrader.Upgrade(w, r, http.Header{requestIDHeader: {requestID}})
+	if err != nil {
+		log.Printf("WebSocket upgrade error: %v", err)
+		return
+	}
+
+	ctx, cancel := context.WithCancel(r.Context())
+	client := &Client{
+		hub:           h,
+		conn:          conn,
+		send:          make(chan []byte, h.opts.SendQueueSize),
+		requestID:     requestID,
+		ctx:           ctx,
+		cancel:        cancel,
+		subscriptions: make(map[string]*ClientSubscription),
+		done:          make(chan struct{}),
+		readDone:      make(chan struct{}),
+		writeDone:     make(chan struct{}),
+	}
+
+	// Register client
+	h.mu.Lock()
+	h.clients[client] = true
+	h.wg.Add(1)
+	if h.closing {
+		client.close(websocket.CloseGoingAway, h.closeReason)
+	}
+	h.mu.Unlock()
+	defer h.wg.Done()
+
+	log.Printf("New WebSocket connection established: %s", conn.RemoteAddr())
+
+	go client.writePump()
+	client.readPump()
+	close(client.readDone)
+
+	// Remove client when connection closes
+	h.mu.Lock()
+	delete(h.clients, client)
+	h.mu.Unlock()
+	client.close(websocket.CloseNormalClosure, "")
+	<-client.writeDone
+
+	client.mu.Lock()
+	for _, sub := range client.subscriptions {
+		h.watch(streamKey(sub.request), -1)
+	}
+	client.mu.Unlock()
+
+	client.mu.Lock()
+	dropped := client.dropped
+	client.mu.Unlock()
+
+	if dropped > 0 {
+		log.Printf("WebSocket connection closed: %s (%d frames dropped)", conn.RemoteAddr(), dropped)
+	} else {
+		log.Printf("WebSocket connection closed: %s", conn.RemoteAddr())
+	}
+}
+
+// Broadcast quotes every subscription of every client and every event
+// stream, and queues the updates
+func (h *Hub) Broadcast(ctx context.Context) {
+	for _, client := range h.snapshot() {
+		client.mu.Lock()
+		for _, sub := range client.subscriptions {
+			client.sendUpdate(ctx, sub, false)
+		}
+		client.mu.Unlock()
+	}
+
+	h.broadcastStreams(ctx)
+}
+
+// Close sends a close frame with the given reason to every WebSocket client
+// and ends every event stream. Connections opened afterwards are closed right
+// away. It does not wait; see Wait.
+func (h *Hub) Close(reason string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.closing = true
+	h.closeReason = reason
+
+	for client := range h.clients {
+		client.close(websocket.CloseGoingAway, reason)
+	}
+	for _, streams := range h.streams {
+		for stream := range streams {
+			stream.end()
+		}
+	}
+}
+
+// Wait blocks until every WebSocket connection has finished, or until ctx is
+// done
+func (h *Hub) Wait(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		h.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (h *Hub) snapshot() []*Client {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	clients := make([]*Client, 0, len(h.clients))
+	for client := range h.clients {
+		clients = append(clients, client)
+	}
+	return clients
+}
+
+// Read client frames until the connection fails or is closed
+func (c *Client) readPump() {
+	c.conn.SetReadLimit(maxClientMessageSize)
+	c.conn.SetReadDeadline(time.Now().Add(c.hub.opts.PongWait))
+	c.conn.SetPongHandler(func(string) error {
+		return c.conn.SetReadDeadline(time.Now().Add(c.hub.opts.PongWait))
+	})
+
+	// Handle incoming messages
+	for {
+		_, message, err := c.conn.ReadMessage()
+		if err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure, websocket.CloseNormalClosure, websocket.CloseTryAgainLater) {
+				log.Printf("WebSocket error: %v", err)
+			}
+			return
+		}
+
+		c.handleMessage(message)
+	}
+}
+
+// Write queued frames and keepalive pings. This is the only goroutine that
+// writes to the connection.
+func (c *Client) writePump() {
+	ticker := time.NewTicker(c.hub.opts.PongWait * 9 / 10)
+	defer func() {
+		ticker.Stop()
+		c.conn.Close()
+		close(c.writeDone)
+	}()
+
+	for {
+		select {
+		case frame := <-c.send:
+			c.conn.SetWriteDeadline(time.Now().Add(c.hub.opts.WriteWait))
+			if err := c.conn.WriteMessage(websocket.TextMessage, frame); err != nil {
+				c.close(websocket.CloseAbnormalClosure, "")
+				return
+			}
+
+		case <-ticker.C:
+			c.conn.SetWriteDeadline(time.Now().Add(c.hub.opts.WriteWait))
+			if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				c.close(websocket.CloseAbnormalClosure, "")
+				return
+			}
+
+		case <-c.done:
+			if c.closeCode != websocket.CloseAbnormalClosure {
+				message := websocket.FormatCloseMessage(c.closeCode, c.closeReason)
+				c.conn.WriteControl(websocket.CloseMessage, message, time.Now().Add(c.hub.opts.WriteWait))
+
+				// Let the peer answer before the connection is torn down
+				timer := time.NewTimer(closeGracePeriod)
+				select {
+				case <-c.readDone:
+				case <-timer.C:
+				}
+				timer.Stop()
+			}
+			return
+		}
+	}
+}
+
+// Close the connection with a close frame. Only the first call has an effect.
+func (c *Client) close(code int, reason string) {
+	c.closeOnce.Do(func() {
+		c.closeCode = code
+		c.closeReason = reason
+		close(c.done)
+		c.cancel()
+	})
+}
+
+// Queue a frame for the writer without blocking. When the queue is full the
+// hub's slow consumer policy decides whether to drop the oldest frame or to
+// disconnect the client. The caller holds c.mu.
+func (c *Client) enqueue(frame []byte) {
+	select {
+	case <-c.done:
+		return
+	default:
+	}
+
+	for {
+		select {
+		case c.send <- frame:
+			return
+		default:
+		}
+
+		if c.hub.opts.SlowConsumerPolicy == SlowConsumerDisconnect {
+			log.Printf("Disconnecting slow WebSocket client %s", c.conn.RemoteAddr())
+			c.close(websocket.CloseTryAgainLater, "client too slow")
+			return
+		}
+
+		// Make room by dropping the oldest queued frame. It may have been
+		// an update the client needs to follow the deltas after it, so
+		// every subscription sends a full update next.
+		select {
+		case <-c.send:
+			c.dropped++
+			if c.dropped == 1 {
+				log.Printf("Dropping frames for slow WebSocket client %s", c.conn.RemoteAddr())
+			}
+			for _, sub := range c.subscriptions {
+				sub.sent = nil
+			}
+		default:
+		}
+	}
+}
+
+// Marshal and queue a frame
+func (c *Client) enqueueJSON(v interface{}) {
+	frame, err := json.Marshal(v)
+	if err != nil {
+		log.Printf("Error marshaling WebSocket message: %v", err)
+		return
+	}
+	c.enqueue(frame)
+}
+
+// Process one client frame
+func (c *Client) handleMessage(message []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	msg, perr := decodeClientMessage(message)
+	if perr != nil {
+		c.sendServerMessage(ServerMessage{Type: MessageError, Ref: msg.Ref, ID: msg.ID, Error: perr})
+		return
+	}
+
+	switch msg.Type {
+	case MessagePing:
+		c.sendServerMessage(ServerMessage{Type: MessageAck, Ref: msg.Ref, Ack: MessagePing})
+
+	case MessageUnsubscribe:
+		if _, exists := c.subscriptions[msg.ID]; !exists {
+			c.sendServerMessage(ServerMessage{Type: MessageError, Ref: msg.Ref, ID: msg.ID, Error: &ProtocolError{
+				Code:    ErrCodeUnknownSubscription,
+				Message: fmt.Sprintf("no subscription with id %q", msg.ID),
+			}})
+			return
+		}
+		c.hub.watch(streamKey(c.subscriptions[msg.ID].request), -1)
+		delete(c.subscriptions, msg.ID)
+		c.sendServerMessage(ServerMessage{Type: MessageAck, Ref: msg.Ref, ID: msg.ID, Ack: MessageUnsubscribe})
+
+	case MessageSnapshot:
+		sub, exists := c.subscriptions[msg.ID]
+		if !exists {
+			c.sendServerMessage(ServerMessage{Type: MessageError, Ref: msg.Ref, ID: msg.ID, Error: &ProtocolError{
+				Code:    ErrCodeUnknownSubscription,
+				Message: fmt.Sprintf("no subscription with id %q", msg.ID),
+			}})
+			return
+		}
+		c.sendServerMessage(ServerMessage{Type: MessageAck, Ref: msg.Ref, ID: msg.ID, Ack: MessageSnapshot})
+		c.sendUpdate(c.ctx, sub, true)
+
+	case MessageSubscribe:
+		request := msg.RealTimeRequest
+		if perr := validateSubscription(request); perr != nil {
+			c.sendServerMessage(ServerMessage{Type: MessageError, Ref: msg.Ref, ID: request.ID, Error: perr})
+			return
+		}
+		if perr := checkCatalog(request); perr != nil {
+			c.sendServerMessage(ServerMessage{Type: MessageError, Ref: msg.Ref, ID: request.ID, Error: perr})
+			return
+		}
+
+		if _, exists := c.subscriptions[request.ID]; !exists && len(c.subscriptions) >= maxSubscriptionsPerConn {
+			c.sendServerMessage(ServerMessage{Type: MessageError, Ref: msg.Ref, ID: request.ID, Error: &ProtocolError{
+				Code:    ErrCodeSubscriptionLimit,
+				Message: fmt.Sprintf("a connection can hold at most %d subscriptions", maxSubscriptionsPerConn),
+			}})
+			return
+		}
+
+		// Rules were validated above, so there are no errors to report here
+		alerts, _ := newAlertState(request.Alerts)
+
+		// Register subscription
+		sub := &ClientSubscription{
+			request: request,
+			alerts:  alerts,
+		}
+		if previous, exists := c.subscriptions[request.ID]; exists {
+			c.hub.watch(streamKey(previous.request), -1)
+		}
+		c.hub.watch(streamKey(request), 1)
+		c.subscriptions[request.ID] = sub
+		c.sendServerMessage(ServerMessage{Type: MessageAck, Ref: msg.Ref, ID: request.ID, Ack: MessageSubscribe})
+
+		// Send a full snapshot immediately
+		c.sendUpdate(c.ctx, sub, true)
+	}
+}
+
+// Queue an ack or error frame
+func (c *Client) sendServerMessage(msg ServerMessage) {
+	msg.Version = protocolVersion
+	msg.Timestamp = c.hub.opts.Clock.Now().Unix()
+	if msg.Error != nil {
+		tagged := *msg.Error
+		tagged.RequestID = c.requestID
+		msg.Error = &tagged
+	}
+	c.enqueueJSON(msg)
+}
+
+// Queue the latest offers for a subscription, followed by any alerts they
+// fire. Unless full is set, only the providers that changed since the last
+// update are sent, and nothing is sent when none did. A failed quote is
+// reported with an error frame when it starts failing, or on a full update.
+// The caller holds c.mu.
+func (c *Client) sendUpdate(ctx context.Context, sub *ClientSubscription, full bool) {
+	response, perr := c.hub.buildRealTimeResponse(ctx, sub.request)
+	if perr != nil {
+		// Quotes fail when the connection closes, with nobody to tell
+		if (full || !sub.failing) && ctx.Err() == nil {
+			c.sendServerMessage(ServerMessage{Type: MessageError, ID: sub.request.ID, Error: perr})
+		}
+		sub.failing = true
+		return
+	}
+	sub.failing = false
+
+	// Alerts always look at the complete offer list
+	alerts := sub.alerts.evaluate(response)
+
+	if full || sub.sent == nil {
+		sub.sent = snapshotOffers(response.Offers)
+		response.Full = true
+	} else {
+		changed, removed, next := diffOffers(sub.sent, response.Offers)
+		sub.sent = next
+		response.Offers = changed
+		response.Removed = removed
+	}
+
+	if response.Full || len(response.Offers) > 0 || len(response.Removed) > 0 {
+		sub.seq++
+		response.Seq = sub.seq
+		c.enqueueJSON(response)
+	}
+
+	for _, alert := range alerts {
+		c.enqueueJSON(AlertMessage{
+			Version:   protocolVersion,
+			Type:      MessageAlert,
+			Alert:     alert,
+			Timestamp: response.Timestamp,
+		})
+	}
+}
+
+// Build the update frame for a request, or the error to send when no
+// provider could quote it
+func (h *Hub) buildRealTimeResponse(ctx context.Context, request RealTimeRequest) (RealTimeResponse, *ProtocolError) {
+	var (
+		route    string
+		location string
+	)
+
+	switch request.Category {
+	case CategoryTaxi:
+		route = fmt.Sprintf("%s to %s",
+			describeTaxiEndpoint(request.FromState, request.FromCity, request.FromAddress),
+			describeTaxiEndpoint(request.ToState, request.ToCity, request.ToAddress))
+	case CategoryRestaurant, CategoryQuickCommerce:
+		location = fmt.Sprintf("%s, %s", request.City, request.State)
+	}
+
+	offers, err := getOrQuoteOffers(ctx, request)
+	if err != nil {
+		return RealTimeResponse{}, quoteError(err)
+	}
+	if len(offers) == 0 {
+		return RealTimeResponse{}, &ProtocolError{
+			Code:    ErrCodeQuoteFailed,
+			Message: "no provider returned offers",
+		}
+	}
+
+	return RealTimeResponse{
+		Version:   protocolVersion,
+		Type:      MessageUpdate,
+		ID:        request.ID,
+		Category:  request.Category,
+		Route:     route,
+		Location:  location,
+		Offers:    offers,
+		Timestamp: h.opts.Clock.Now().Unix(),
+	}, nil
+}
+
+// Name one end of a taxi route by its most specific parts
+func describeTaxiEndpoint(state, city, address string) string {
+	switch {
+	case address != "":
+		return address + ", " + city
+	case city != "":
+		return city
+	}
+	return state
+}
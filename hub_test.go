@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+// Connect a WebSocket client to the hub without starting its pumps, so that
+// the frames it is sent stay in its queue
+func newTestClient(t *testing.T, hub *Hub) *Client {
+	t.Helper()
+
+	conns := make(chan *websocket.Conn, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Errorf("Upgrade: %v", err)
+			return
+		}
+		conns <- conn
+	}))
+	t.Cleanup(server.Close)
+
+	dialed, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	t.Cleanup(func() { dialed.Close() })
+	conn := <-conns
+	t.Cleanup(func() { conn.Close() })
+
+	return &Client{
+		hub:           hub,
+		conn:          conn,
+		send:          make(chan []byte, hub.opts.SendQueueSize),
+		subscriptions: make(map[string]*ClientSubscription),
+		done:          make(chan struct{}),
+	}
+}
+
+func newTestSubscription(request RealTimeRequest) *ClientSubscription {
+	alerts, _ := newAlertState(request.Alerts)
+	return &ClientSubscription{request: request, alerts: alerts}
+}
+
+// Take the queued update frames of a client
+func queuedUpdates(t *testing.T, client *Client) []RealTimeResponse {
+	t.Helper()
+
+	var updates []RealTimeResponse
+	for {
+		select {
+		case frame := <-client.send:
+			var update RealTimeResponse
+			if err := json.Unmarshal(frame, &update); err != nil {
+				t.Fatalf("Unmarshal %s: %v", frame, err)
+			}
+			updates = append(updates, update)
+		default:
+			return updates
+		}
+	}
+}
+
+func TestClientSendsOnlyChangedOffers(t *testing.T) {
+	useTestProviders(t,
+		&fakeProvider{name: "Uber", category: CategoryTaxi, price: 500},
+		&fakeProvider{name: "Ola", category: CategoryTaxi, price: 450})
+	client := newTestClient(t, NewHub(HubOptions{SendQueueSize: 8}))
+	request := testTaxiRequest()
+	sub := newTestSubscription(request)
+	client.subscriptions[""] = sub
+
+	client.sendUpdate(context.Background(), sub, true)
+	setTestPrice(t, request, "Uber", 510)
+	client.sendUpdate(context.Background(), sub, false)
+	client.sendUpdate(context.Background(), sub, false)
+
+	updates := queuedUpdates(t, client)
+	if len(updates) != 2 {
+		t.Fatalf("got %d updates, want a snapshot and one delta", len(updates))
+	}
+	if !updates[0].Full || len(updates[0].Offers) != 2 || updates[0].Seq != 1 {
+		t.Errorf("first update = %+v, want a full update of both offers", updates[0])
+	}
+	delta := updates[1]
+	if delta.Full || delta.Seq != 2 || len(delta.Offers) != 1 || delta.Offers[0].ServiceName != "Uber" || delta.Offers[0].Price != 510 {
+		t.Errorf("second update = %+v, want only the new Uber price", delta)
+	}
+}
+
+// A dropped frame may be an update later deltas build on, so the next update
+// must be full again
+func TestClientSendsFullUpdateAfterDroppedFrame(t *testing.T) {
+	useTestProviders(t, &fakeProvider{name: "Uber", category: CategoryTaxi, price: 500})
+	client := newTestClient(t, NewHub(HubOptions{SendQueueSize: 1, SlowConsumerPolicy: SlowConsumerDrop}))
+	request := testTaxiRequest()
+	sub := newTestSubscription(request)
+	client.subscriptions[""] = sub
+
+	client.sendUpdate(context.Background(), sub, true)
+	setTestPrice(t, request, "Uber", 510)
+	// The snapshot is dropped to make room for this delta
+	client.sendUpdate(context.Background(), sub, false)
+	if updates := queuedUpdates(t, client); len(updates) != 1 || updates[0].Full {
+		t.Fatalf("queued updates = %+v, want only the delta", updates)
+	}
+
+	client.sendUpdate(context.Background(), sub, false)
+	updates := queuedUpdates(t, client)
+	if len(updates) != 1 || !updates[0].Full || updates[0].Offers[0].Price != 510 {
+		t.Errorf("update after the drop = %+v, want a full update", updates)
+	}
+}
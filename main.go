@@ -1,13 +1,18 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"flag"
 	"fmt"
 	"log"
-	"math/rand"
+	"math"
 	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
 	"strings"
-	"sync"
+	"syscall"
 	"time"
 
 	"github.com/gorilla/mux"
@@ -20,6 +25,18 @@ type ServiceOffer struct {
 	Offer        string  `json:"Offer"`
 	DeliveryTime int     `json:"DeliveryTime,omitempty"`
 	Duration     int     `json:"Duration,omitempty"`
+	// Demand multiplier already included in Price (taxi only)
+	Surge float64 `json:"surge,omitempty"`
+	// Where the money goes; the parts add up to Price
+	Breakdown *PriceBreakdown `json:"breakdown,omitempty"`
+	// Offer parsed into rules, and Price after applying them
+	Promotions     []Promotion `json:"promotions,omitempty"`
+	EffectivePrice float64     `json:"effectivePrice,omitempty"`
+	// Place in the request's ranking, starting at 1, with its score (lower
+	// is better) and badges
+	Rank   int      `json:"rank,omitempty"`
+	Score  float64  `json:"score,omitempty"`
+	Badges []string `json:"badges,omitempty"`
 }
 
 // Available categories
@@ -29,18 +46,16 @@ const (
 	CategoryQuickCommerce = "quickcommerce"
 )
 
-var taxiServices = map[string][]ServiceOffer{
-	"india:delhi:india:mumbai": {
-		{ServiceName: "Uber", Price: 6500.00, Offer: "10% cashback", Duration: 1260},
-		{ServiceName: "Ola", Price: 7000.00, Offer: "Free waiting", Duration: 1200},
-	},
+// Seed offers for well-known routes and restaurants. Only places the catalog
+// accepts are seeded, as requests for any other place never reach the store.
+var defaultTaxiOffers = map[string][]ServiceOffer{
 	"india:punjab:india:himachal pradesh": {
 		{ServiceName: "Uber", Price: 1700.00, Offer: "₹100 off", Duration: 240},
 		{ServiceName: "Ola", Price: 1600.00, Offer: "20% off first ride", Duration: 210},
 	},
 }
 
-var restaurantServices = map[string][]ServiceOffer{
+var defaultRestaurantOffers = map[string][]ServiceOffer{
 	"india:punjab:patiala:dominos": {
 		{ServiceName: "Zomato", Price: 350.00, Offer: "20% off", DeliveryTime: 30},
 		{ServiceName: "Swiggy", Price: 320.00, Offer: "Free drink", DeliveryTime: 25},
@@ -51,16 +66,9 @@ var restaurantServices = map[string][]ServiceOffer{
 	},
 }
 
-var quickCommerceServices = map[string][]ServiceOffer{
-	"india:punjab:patiala:thapar university": {
-		{ServiceName: "Zepto", Price: 120.00, Offer: "Free delivery", DeliveryTime: 10},
-		{ServiceName: "Blinkit", Price: 110.00, Offer: "15% off", DeliveryTime: 12},
-	},
-	"india:delhi:delhi:india gate": {
-		{ServiceName: "Zepto", Price: 150.00, Offer: "₹30 cashback", DeliveryTime: 15},
-		{ServiceName: "Blinkit", Price: 140.00, Offer: "Buy 1 Get 1", DeliveryTime: 20},
-	},
-}
+// offerStore holds the offers served by the REST handlers and the WebSocket
+// path. It is created in main once the storage driver is open.
+var offerStore *OfferStore
 
 var locationOptions = map[string]interface{}{
 	"countries": []string{"India"},
@@ -112,50 +120,67 @@ var locationOptions = map[string]interface{}{
 	"addresses":   map[string]map[string][]string{},
 }
 
-var upgrader = websocket.Upgrader{
-	ReadBufferSize:  1024,
-	WriteBufferSize: 1024,
-	CheckOrigin: func(r *http.Request) bool {
-		return true // Allow all connections for now
-	},
-}
+// upgrader is built from the WebSocket settings in main
+var upgrader websocket.Upgrader
 
 type RealTimeRequest struct {
+	// Client-supplied subscription ID, echoed in every response. A new
+	// request with the same ID replaces the previous subscription.
+	ID          string `json:"id,omitempty"`
 	Category    string `json:"category"`
 	FromCountry string `json:"fromCountry,omitempty"`
 	FromState   string `json:"fromState,omitempty"`
 	ToCountry   string `json:"toCountry,omitempty"`
 	ToState     string `json:"toState,omitempty"`
+	// Optional, more precise taxi endpoints. An address needs its city.
+	FromCity    string `json:"fromCity,omitempty"`
+	FromAddress string `json:"fromAddress,omitempty"`
+	ToCity      string `json:"toCity,omitempty"`
+	ToAddress   string `json:"toAddress,omitempty"`
 	Country     string `json:"country,omitempty"`
 	State       string `json:"state,omitempty"`
 	City        string `json:"city,omitempty"`
 	Restaurant  string `json:"restaurant,omitempty"`
 	Address     string `json:"address,omitempty"`
 	GroceryItem string `json:"groceryItem,omitempty"`
+	// Alert rules evaluated on every update of the subscription
+	Alerts []AlertRule `json:"alerts,omitempty"`
+	// Weight of time against cost when ranking offers, from 0 (cost only)
+	// to 1 (time only). Defaults to 0.3.
+	TimeWeight *float64 `json:"timeWeight,omitempty"`
 }
 
+// RealTimeResponse is an update frame. Full snapshots carry every offer;
+// other updates only carry the providers that changed since the previous
+// frame, plus the providers that disappeared in Removed.
 type RealTimeResponse struct {
+	Version int    `json:"v"`
+	Type    string `json:"type"`
+	ID      string `json:"id,omitempty"`
+	// Sequence number of the update within its subscription or stream
+	Seq       uint64         `json:"seq"`
+	Full      bool           `json:"full"`
 	Category  string         `json:"category"`
 	Route     string         `json:"route,omitempty"`
 	Location  string         `json:"location,omitempty"`
 	Offers    []ServiceOffer `json:"offers"`
+	Removed   []string       `json:"removed,omitempty"`
 	Timestamp int64          `json:"timestamp"`
 }
 
-type ClientSubscription struct {
-	request RealTimeRequest
-	conn    *websocket.Conn
-}
+// clock is the time source of the HTTP handlers, the hub and the price
+// updates. main sets it from the startTime setting.
+var clock Clock = systemClock{}
 
-var (
-	clients       = make(map[*websocket.Conn]bool)
-	subscriptions = make(map[*websocket.Conn]*ClientSubscription)
-	clientsMutex  = sync.Mutex{}
-	seed          = rand.NewSource(time.Now().UnixNano())
-	rnd           = rand.New(seed)
-)
+// surge computes demand-based multipliers for taxi fares. It is created in
+// main from the surge settings.
+var surge *SurgeEngine
+
+// hub fans real-time updates out to the WebSocket clients. It is created in
+// main from the WebSocket settings.
+var hub *Hub
 
-func getDynamicRestaurantOptions() map[string]map[string][]string {
+func getDynamicRestaurantOptions(random RandomSource) map[string]map[string][]string {
 	options := make(map[string]map[string][]string)
 
 	chains := []string{
@@ -172,15 +197,15 @@ func getDynamicRestaurantOptions() map[string]map[string][]string {
 		if cities, ok := locationOptions["cities"].(map[string]map[string][]string)["India"][state]; ok {
 			for _, city := range cities {
 
-				numRestaurants := 3 + rand.Intn(5)
+				numRestaurants := 3 + random.Intn(5)
 				cityRestaurants := make([]string, 0, numRestaurants)
 
 				selectedIndexes := make(map[int]bool)
 				for i := 0; i < numRestaurants; i++ {
-					idx := rand.Intn(len(chains))
+					idx := random.Intn(len(chains))
 
 					for selectedIndexes[idx] {
-						idx = rand.Intn(len(chains))
+						idx = random.Intn(len(chains))
 					}
 					selectedIndexes[idx] = true
 					cityRestaurants = append(cityRestaurants, chains[idx])
@@ -195,7 +220,7 @@ func getDynamicRestaurantOptions() map[string]map[string][]string {
 }
 
 // Generate dynamic address options for every city
-func getDynamicAddressOptions() map[string]map[string][]string {
+func getDynamicAddressOptions(random RandomSource) map[string]map[string][]string {
 	options := make(map[string]map[string][]string)
 
 	// Common address patterns across India
@@ -215,16 +240,16 @@ func getDynamicAddressOptions() map[string]map[string][]string {
 		if cities, ok := locationOptions["cities"].(map[string]map[string][]string)["India"][state]; ok {
 			for _, city := range cities {
 				// Add 3-6 addresses for each city
-				numAddresses := 3 + rand.Intn(4) // 3 to 6 addresses
+				numAddresses := 3 + random.Intn(4) // 3 to 6 addresses
 				cityAddresses := make([]string, 0, numAddresses)
 
 				// Select random addresses without duplicates
 				selectedIndexes := make(map[int]bool)
 				for i := 0; i < numAddresses; i++ {
-					idx := rand.Intn(len(addressPatterns))
+					idx := random.Intn(len(addressPatterns))
 					// Avoid duplicates
 					for selectedIndexes[idx] {
-						idx = rand.Intn(len(addressPatterns))
+						idx = random.Intn(len(addressPatterns))
 					}
 					selectedIndexes[idx] = true
 					cityAddresses = append(cityAddresses, addressPatterns[idx])
@@ -260,292 +285,317 @@ func getDynamicGroceryOptions() map[string][]string {
 	return groceryOptions
 }
 
+// Load a generated catalog from storage, or generate and store it on first run
+// so that restaurants and addresses stay the same across restarts
+func loadOrGenerateCatalog(storage Storage, name string, generate func(RandomSource) map[string]map[string][]string, random RandomSource) (map[string]map[string][]string, error) {
+	var catalog map[string]map[string][]string
+	found, err := storage.LoadCatalog(name, &catalog)
+	if err != nil {
+		return nil, fmt.Errorf("loading %s catalog: %w", name, err)
+	}
+	if found {
+		return catalog, nil
+	}
+
+	catalog = generate(random)
+	if err := storage.SaveCatalog(name, catalog); err != nil {
+		return nil, fmt.Errorf("saving %s catalog: %w", name, err)
+	}
+	return catalog, nil
+}
+
 // Initialize the dynamic options for restaurants, addresses, and grocery items
-func initializeDynamicOptions() {
+func initializeDynamicOptions(storage Storage, random RandomSource) error {
 	// Initialize restaurant options
-	restaurants := getDynamicRestaurantOptions()
+	restaurants, err := loadOrGenerateCatalog(storage, "restaurants", getDynamicRestaurantOptions, forkRandomSource(random))
+	if err != nil {
+		return err
+	}
 	locationOptions["restaurants"] = restaurants
 
 	// Initialize address options
-	addresses := getDynamicAddressOptions()
+	addresses, err := loadOrGenerateCatalog(storage, "addresses", getDynamicAddressOptions, forkRandomSource(random))
+	if err != nil {
+		return err
+	}
 	locationOptions["addresses"] = addresses
 
 	// Initialize grocery items
 	groceryItems := getDynamicGroceryOptions()
 	locationOptions["groceryItems"] = groceryItems
+
+	return nil
 }
 
 func main() {
+	cfg, err := loadConfig(os.Args[1:], os.Getenv)
+	if err != nil {
+		if err == flag.ErrHelp {
+			return
+		}
+		log.Fatalf("Error loading configuration: %v", err)
+	}
+
 	fmt.Println("Starting Multi-Service Price Comparator API")
 
+	// Every generated price and catalog derives from this seed
+	seed := cfg.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	log.Printf("Using random seed %d", seed)
+	random := NewRandomSource(seed)
+
+	clock = cfg.clock()
+	if cfg.StartTime != "" {
+		log.Printf("Starting the clock at %s", cfg.StartTime)
+	}
+
+	upgrader = cfg.WebSocket.upgrader()
+	hubOptions := cfg.WebSocket.hubOptions()
+	hubOptions.Clock = clock
+	hub = NewHub(hubOptions)
+	surge = NewSurgeEngine(cfg.Surge, clock, hub.Subscribers)
+	lenientCatalog = cfg.LenientCatalog
+
+	// Open the storage backend
+	storage, err := openStorage(cfg.Storage, cfg.DBPath)
+	if err != nil {
+		log.Fatalf("Error opening storage: %v", err)
+	}
+	defer storage.Close()
+
+	// Load the coordinates used for taxi distances
+	gazetteer, err = loadGazetteer(gazetteerData)
+	if err != nil {
+		log.Fatalf("Error loading gazetteer: %v", err)
+	}
+	if err := gazetteer.checkCoverage(locationOptions); err != nil {
+		log.Fatalf("Error loading gazetteer: %v", err)
+	}
+
 	// Initialize dynamic location options
-	initializeDynamicOptions()
+	if err := initializeDynamicOptions(storage, forkRandomSource(random)); err != nil {
+		log.Fatalf("Error initializing options: %v", err)
+	}
+
+	// Load the menus behind dish comparisons
+	menus, err = loadMenus(menuData)
+	if err != nil {
+		log.Fatalf("Error loading menus: %v", err)
+	}
+	if err := menus.checkCoverage(locationOptions); err != nil {
+		log.Fatalf("Error loading menus: %v", err)
+	}
+
+	// Load stored offers, falling back to the seed data
+	offerStore, err = NewOfferStore(storage, map[string]map[string][]ServiceOffer{
+		CategoryTaxi:       defaultTaxiOffers,
+		CategoryRestaurant: defaultRestaurantOffers,
+	})
+	if err != nil {
+		log.Fatalf("Error loading offers: %v", err)
+	}
+
+	// Record every price change for /api/history
+	offerStore.Watch(func(records []OfferRecord) {
+		now := clock.Now()
+		for _, record := range records {
+			priceHistory.Record(record, now)
+		}
+	})
+
+	// Register the built-in platforms
+	registerDefaultProviders(providers, forkRandomSource(random))
 
 	r := mux.NewRouter()
 
 	// API Routes
 	api := r.PathPrefix("/api").Subrouter()
+	api.NotFoundHandler = apiFallback(api)
+	api.MethodNotAllowedHandler = api.NotFoundHandler
 
 	// Get options for form fields
 	api.HandleFunc("/options", getOptions).Methods("GET")
 
 	// Compare services by category
+	api.HandleFunc("/compare", compare).Methods("POST")
 	api.HandleFunc("/compare/taxi", compareTaxi).Methods("GET")
 	api.HandleFunc("/compare/restaurant", compareRestaurant).Methods("GET")
+	api.HandleFunc("/compare/restaurant/dishes", compareDishes).Methods("POST")
 	api.HandleFunc("/compare/quickcommerce", compareQuickCommerce).Methods("GET")
+	api.HandleFunc("/compare/quickcommerce/basket", compareBasket).Methods("POST")
+
+	// Price history for the same parameters as the compare endpoints
+	api.HandleFunc("/history", getHistory).Methods("GET")
+
+	// Server-Sent Events alternative to the WebSocket endpoint
+	api.HandleFunc("/stream", hub.ServeSSE).Methods("GET")
 
 	// WebSocket endpoint for real-time updates
-	r.HandleFunc("/ws", handleWebSocket)
+	r.HandleFunc("/ws", hub.ServeWS)
 
 	// Serve static files (frontend)
-	r.PathPrefix("/").Handler(http.StripPrefix("/", http.FileServer(http.Dir("./frontend"))))
+	r.PathPrefix("/").Handler(http.StripPrefix("/", http.FileServer(http.Dir(cfg.FrontendDir))))
 
-	// Start real-time price update goroutine
-	go updatePricesRoutine()
+	server := &http.Server{
+		Addr:    cfg.Addr,
+		Handler: withRequestID(r),
+	}
 
-	// Start server
-	fmt.Println("Server is running on http://localhost:5000")
-	fmt.Println("WebSocket server available at ws://localhost:5000/ws")
-	log.Fatal(http.ListenAndServe("localhost:5000", r))
-}
+	// WebSocket connections are hijacked, so the server does not track them.
+	// Close them with a close frame as soon as shutdown begins.
+	server.RegisterOnShutdown(func() {
+		hub.Close("server shutting down")
+	})
 
-// Handle WebSocket connections
-func handleWebSocket(w http.ResponseWriter, r *http.Request) {
-	// Upgrade HTTP connection to WebSocket
-	conn, err := upgrader.Upgrade(w, r, nil)
+	// Stop on SIGINT or SIGTERM
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// Start real-time price update goroutine
+	updatesCtx, stopUpdates := context.WithCancel(ctx)
+	updatesDone := make(chan struct{})
+	fluctuator, err := newPriceFluctuator(cfg.fluctuationModels(), cfg.FluctuationPercent, forkRandomSource(random), clock, storage)
 	if err != nil {
-		log.Printf("WebSocket upgrade error: %v", err)
-		return
+		log.Fatalf("Error loading price fluctuation state: %v", err)
 	}
-	defer conn.Close()
-
-	// Register client
-	clientsMutex.Lock()
-	clients[conn] = true
-	clientsMutex.Unlock()
-
-	log.Printf("New WebSocket connection established: %s", conn.RemoteAddr())
+	go func() {
+		defer close(updatesDone)
+		updatePricesRoutine(updatesCtx, cfg.UpdateInterval.Duration, fluctuator)
+	}()
 
-	// Remove client when connection closes
-	defer func() {
-		clientsMutex.Lock()
-		delete(clients, conn)
-		delete(subscriptions, conn)
-		clientsMutex.Unlock()
-		log.Printf("WebSocket connection closed: %s", conn.RemoteAddr())
+	// Start server
+	serverErr := make(chan error, 1)
+	go func() {
+		serverErr <- server.ListenAndServe()
 	}()
+	fmt.Printf("Server is running on http://%s\n", cfg.Addr)
+	fmt.Printf("WebSocket server available at ws://%s/ws\n", cfg.Addr)
 
-	// Handle incoming messages
-	for {
-		_, message, err := conn.ReadMessage()
-		if err != nil {
-			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
-				log.Printf("WebSocket error: %v", err)
-			}
-			break
-		}
+	select {
+	case err := <-serverErr:
+		log.Fatalf("Server error: %v", err)
+	case <-ctx.Done():
+	}
 
-		// Process subscription request
-		var request RealTimeRequest
-		if err := json.Unmarshal(message, &request); err != nil {
-			log.Printf("Error unmarshaling WebSocket message: %v", err)
-			continue
-		}
+	// A second signal kills the process right away
+	stop()
+	log.Printf("Shutting down, waiting up to %s", cfg.ShutdownTimeout.Duration)
 
-		// Register subscription
-		clientsMutex.Lock()
-		subscriptions[conn] = &ClientSubscription{
-			request: request,
-			conn:    conn,
-		}
-		clientsMutex.Unlock()
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout.Duration)
+	defer cancel()
+
+	stopUpdates()
+	<-updatesDone
 
-		// Send initial data immediately
-		sendRealTimeResponse(conn, request)
+	// Stop accepting connections and drain in-flight requests and event
+	// streams, then wait for the WebSocket close handshakes
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		log.Printf("Error draining HTTP requests: %v", err)
+	}
+	if err := hub.Wait(shutdownCtx); err != nil {
+		log.Printf("Error closing WebSocket connections: %v", err)
 	}
+
+	log.Println("Server stopped")
 }
 
-// Update prices routine
-func updatePricesRoutine() {
-	ticker := time.NewTicker(5 * time.Second)
+// Update prices every interval with the fluctuation model of each category,
+// until ctx is canceled
+func updatePricesRoutine(ctx context.Context, interval time.Duration, fluctuator *priceFluctuator) {
+	ticker := time.NewTicker(interval)
 	defer ticker.Stop()
 
 	for {
 		select {
 		case <-ticker.C:
-			// Apply small random fluctuations to prices
-			applyPriceFluctuations()
-
-			// Send updates to all clients
-			clientsMutex.Lock()
-			for conn, sub := range subscriptions {
-				if conn.WriteMessage(websocket.PingMessage, nil) != nil {
-					// Connection is dead
-					delete(clients, conn)
-					delete(subscriptions, conn)
-					conn.Close()
-					continue
-				}
-
-				// Send updated data
-				sendRealTimeResponse(conn, sub.request)
-			}
-			clientsMutex.Unlock()
-		}
-	}
-}
-
-// Apply random price fluctuations to service offers (simulating real-time changes)
-func applyPriceFluctuations() {
-	// Apply to taxi services
-	for key, offers := range taxiServices {
-		for i := range offers {
-			// Random fluctuation between -5% and +5%
-			fluctuation := 1.0 + (rnd.Float64()*0.1 - 0.05)
-			offers[i].Price = offers[i].Price * fluctuation
-			// Round to 2 decimal places
-			offers[i].Price = float64(int(offers[i].Price*100)) / 100
-		}
-		taxiServices[key] = offers
-	}
+			// Move prices according to the fluctuation models
+			fluctuator.apply(offerStore)
 
-	// Apply to restaurant services
-	for key, offers := range restaurantServices {
-		for i := range offers {
-			fluctuation := 1.0 + (rnd.Float64()*0.1 - 0.05)
-			offers[i].Price = offers[i].Price * fluctuation
-			offers[i].Price = float64(int(offers[i].Price*100)) / 100
-		}
-		restaurantServices[key] = offers
-	}
+			// Queue updates for all clients
+			hub.Broadcast(ctx)
 
-	// Apply to quick commerce services
-	for key, offers := range quickCommerceServices {
-		for i := range offers {
-			fluctuation := 1.0 + (rnd.Float64()*0.1 - 0.05)
-			offers[i].Price = offers[i].Price * fluctuation
-			offers[i].Price = float64(int(offers[i].Price*100)) / 100
+		case <-ctx.Done():
+			return
 		}
-		quickCommerceServices[key] = offers
 	}
 }
 
-// Send real-time response to a specific client
-func sendRealTimeResponse(conn *websocket.Conn, request RealTimeRequest) {
-	var (
-		offers   []ServiceOffer
-		route    string
-		location string
-	)
+// Build the lookup key used by the offer maps for a request
+func offerKey(request RealTimeRequest) string {
+	var parts []string
 
 	switch request.Category {
 	case CategoryTaxi:
-		key := fmt.Sprintf("%s:%s:%s:%s",
-			strings.ToLower(request.FromCountry),
-			strings.ToLower(request.FromState),
-			strings.ToLower(request.ToCountry),
-			strings.ToLower(request.ToState))
-
-		// Check if we have pre-existing data
-		if data, exists := taxiServices[key]; exists {
-			offers = data
-		} else {
-			// Generate dynamic offers for any route
-			offers = generateDynamicTaxiOffers(request.FromState, request.ToState)
-			taxiServices[key] = offers
+		parts = []string{request.FromCountry, request.FromState, request.ToCountry, request.ToState}
+		if request.FromCity != "" || request.FromAddress != "" || request.ToCity != "" || request.ToAddress != "" {
+			// City and address routes get their own keys; state routes
+			// keep theirs
+			parts = append(parts, request.FromCity, request.FromAddress, request.ToCity, request.ToAddress)
 		}
-
-		route = fmt.Sprintf("%s to %s", request.FromState, request.ToState)
-
 	case CategoryRestaurant:
-		key := fmt.Sprintf("%s:%s:%s:%s",
-			strings.ToLower(request.Country),
-			strings.ToLower(request.State),
-			strings.ToLower(request.City),
-			strings.ToLower(request.Restaurant))
-
-		// Check if we have pre-existing data
-		if data, exists := restaurantServices[key]; exists {
-			offers = data
-		} else {
-			// Generate dynamic offers for any restaurant in any city
-			offers = generateDynamicRestaurantOffers(request.Restaurant, request.City)
-			restaurantServices[key] = offers
-		}
-
-		location = fmt.Sprintf("%s, %s", request.City, request.State)
-
+		parts = []string{request.Country, request.State, request.City, request.Restaurant}
 	case CategoryQuickCommerce:
-		var baseKey string
+		parts = []string{request.Country, request.State, request.City, request.Address}
 		if request.GroceryItem != "" {
 			// If grocery item is specified, include it in the key
-			baseKey = fmt.Sprintf("%s:%s:%s:%s:%s",
-				strings.ToLower(request.Country),
-				strings.ToLower(request.State),
-				strings.ToLower(request.City),
-				strings.ToLower(request.Address),
-				strings.ToLower(request.GroceryItem))
-		} else {
-			// Otherwise use the standard key
-			baseKey = fmt.Sprintf("%s:%s:%s:%s",
-				strings.ToLower(request.Country),
-				strings.ToLower(request.State),
-				strings.ToLower(request.City),
-				strings.ToLower(request.Address))
-		}
-
-		// Check if we have pre-existing data
-		if data, exists := quickCommerceServices[baseKey]; exists {
-			offers = data
-		} else {
-			// Generate dynamic offers
-			if request.GroceryItem != "" {
-				// Generate offers for specific grocery item
-				offers = generateDynamicGroceryItemOffers(request.GroceryItem, request.Address)
-			} else {
-				// Generate general quick commerce offers
-				offers = generateDynamicQuickCommerceOffers(request.Address, request.City)
-			}
-			quickCommerceServices[baseKey] = offers
+			parts = append(parts, request.GroceryItem)
 		}
+	}
 
-		location = fmt.Sprintf("%s, %s", request.City, request.State)
+	for i := range parts {
+		parts[i] = strings.ToLower(parts[i])
 	}
+	return strings.Join(parts, ":")
+}
 
-	// Skip if no offers found
-	if len(offers) == 0 {
-		return
+// Return the stored offers for a request, asking the registered providers
+// for fresh quotes the first time a key is seen
+func getOrQuoteOffers(ctx context.Context, request RealTimeRequest) ([]ServiceOffer, error) {
+	offers, err := offerStore.GetOrGenerate(request.Category, offerKey(request), func() ([]ServiceOffer, error) {
+		return providers.Quote(ctx, request)
+	})
+	if err != nil {
+		return nil, err
 	}
 
-	// Send response
-	response := RealTimeResponse{
-		Category:  request.Category,
-		Route:     route,
-		Location:  location,
-		Offers:    offers,
-		Timestamp: time.Now().Unix(),
+	// Seed offers and outside providers may come without a breakdown
+	for i := range offers {
+		if offers[i].Breakdown == nil {
+			itemizeOffer(&offers[i])
+		}
 	}
 
-	jsonResponse, err := json.Marshal(response)
-	if err != nil {
-		log.Printf("Error marshaling WebSocket response: %v", err)
-		return
+	// Stored fares exclude surge, which follows current demand
+	if request.Category == CategoryTaxi {
+		surge.Apply(streamKey(request), offers)
 	}
 
-	if err := conn.WriteMessage(websocket.TextMessage, jsonResponse); err != nil {
-		log.Printf("Error sending WebSocket message: %v", err)
+	for i := range offers {
+		applyPromotions(&offers[i])
 	}
+	rankOffers(offers, request.timeWeight())
+	return offers, nil
 }
 
 // Get location options for form fields
 func getOptions(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
-
 	category := r.URL.Query().Get("category")
 	country := r.URL.Query().Get("country")
 	state := r.URL.Query().Get("state")
 	city := r.URL.Query().Get("city")
-	address := r.URL.Query().Get("address") // For getting grocery items
+	address := r.URL.Query().Get("address")       // For getting grocery items
+	restaurant := r.URL.Query().Get("restaurant") // For getting a menu
+
+	switch category {
+	case "", CategoryTaxi, CategoryRestaurant, CategoryQuickCommerce:
+	default:
+		writeError(w, r, fieldErrors("options request is invalid", map[string]string{
+			"category": fmt.Sprintf("unknown category %q", category),
+		}))
+		return
+	}
 
 	var result interface{}
 
@@ -585,7 +635,18 @@ func getOptions(w http.ResponseWriter, r *http.Request) {
 		}
 	default:
 		// Return available restaurants or addresses based on category
-		if category == CategoryRestaurant {
+		if category == CategoryRestaurant && restaurant != "" {
+			// If a restaurant is specified, return its menu
+			if menu, ok := menus.Menu(restaurant); ok {
+				result = map[string][]Dish{
+					"menu": menu.Dishes,
+				}
+			} else {
+				result = map[string][]Dish{
+					"menu": {},
+				}
+			}
+		} else if category == CategoryRestaurant {
 			if restaurants, ok := locationOptions["restaurants"].(map[string]map[string][]string)[state][city]; ok {
 				result = map[string][]string{
 					"restaurants": restaurants,
@@ -595,9 +656,9 @@ func getOptions(w http.ResponseWriter, r *http.Request) {
 					"restaurants": {},
 				}
 			}
-		} else if category == CategoryQuickCommerce {
-			// First return address options
-			if address == "" {
+		} else if category == CategoryTaxi || category == CategoryQuickCommerce {
+			// First return address options; taxis only need addresses
+			if address == "" || category == CategoryTaxi {
 				if addresses, ok := locationOptions["addresses"].(map[string]map[string][]string)[state][city]; ok {
 					result = map[string][]string{
 						"addresses": addresses,
@@ -622,333 +683,120 @@ func getOptions(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	json.NewEncoder(w).Encode(result)
+	writeJSON(w, r, http.StatusOK, result)
 }
 
-// Compare taxi services
-// Generate dynamic taxi offers between any two states
-func generateDynamicTaxiOffers(fromState, toState string) []ServiceOffer {
-	// Calculate base price based on state names
-	// This creates a predictable but unique price for each route
-	fromLen := len(fromState)
-	toLen := len(toState)
-
-	// Use state name length to create base pricing patterns
-	basePrice := float64(fromLen*100 + toLen*120)
-
-	// For intra-state travel, reduce price
-	if strings.EqualFold(fromState, toState) {
-		basePrice = float64(fromLen * 80)
-	}
-
-	// Adjust for popular routes
-	popularStates := map[string]bool{
-		"delhi": true, "maharashtra": true, "karnataka": true,
-		"tamil nadu": true, "telangana": true, "west bengal": true,
-	}
-
-	if popularStates[strings.ToLower(fromState)] && popularStates[strings.ToLower(toState)] {
-		basePrice *= 1.2 // Premium for routes between major states
-	}
-
-	// Calculate trip duration based on states
-	// Assume 60 minutes per letter in state names (silly but deterministic)
-	duration := (fromLen + toLen) * 60
-	if duration < 120 {
-		duration = 120 // Minimum 2 hours
-	}
-
-	// Different pricing and offers for different services
-	uberPrice := basePrice * (1.0 + (rnd.Float64() * 0.1))
-	olaPrice := basePrice * (0.95 + (rnd.Float64() * 0.1)) // Slightly cheaper on average
-
-	// Round to 2 decimal places
-	uberPrice = float64(int(uberPrice*100)) / 100
-	olaPrice = float64(int(olaPrice*100)) / 100
-
-	// Generate appropriate offers based on route
-	uberOffer := "10% cashback"
-	olaOffer := "Free waiting"
-
-	// Different offers for different routes
-	if strings.Contains(strings.ToLower(fromState), "a") {
-		uberOffer = "₹100 off next ride"
-	}
-	if strings.Contains(strings.ToLower(toState), "i") {
-		olaOffer = "20% off first ride"
-	}
-
-	return []ServiceOffer{
-		{ServiceName: "Uber", Price: uberPrice, Offer: uberOffer, Duration: duration},
-		{ServiceName: "Ola", Price: olaPrice, Offer: olaOffer, Duration: duration - 30}, // Slightly faster
+// Look up the entries of a generated catalog ("restaurants" or "addresses")
+// for a city, ignoring case
+func catalogEntries(name, state, city string) []string {
+	catalog, _ := locationOptions[name].(map[string]map[string][]string)
+	for catalogState, cities := range catalog {
+		if !strings.EqualFold(catalogState, state) {
+			continue
+		}
+		for catalogCity, entries := range cities {
+			if strings.EqualFold(catalogCity, city) {
+				return entries
+			}
+		}
 	}
+	return nil
 }
 
-// Generate dynamic restaurant offers for any restaurant in any city
-func generateDynamicRestaurantOffers(restaurant, city string) []ServiceOffer {
-	// Base price depends on restaurant and city names
-	restaurantLen := len(restaurant)
-	cityLen := len(city)
-
-	// Base price formula - creates unique but predictable prices
-	basePrice := float64(restaurantLen*20 + cityLen*15)
-	if basePrice < 150 {
-		basePrice = 150 // Minimum price
-	}
-	if basePrice > 800 {
-		basePrice = 800 // Maximum price
-	}
-
-	// Adjust for premium restaurants
-	premiumRestaurants := map[string]bool{
-		"barbeque nation": true, "theobroma": true, "mcdonald's": true,
-		"pizza hut": true, "kfc": true, "wow! momo": true,
-	}
-
-	if premiumRestaurants[strings.ToLower(restaurant)] {
-		basePrice *= 1.3 // Premium pricing
-	}
-
-	// Different pricing for different services
-	zomatoPrice := basePrice * (1.0 + (rnd.Float64() * 0.1))
-	swiggyPrice := basePrice * (0.95 + (rnd.Float64() * 0.1)) // Slightly cheaper on average
-
-	// Round to 2 decimal places
-	zomatoPrice = float64(int(zomatoPrice*100)) / 100
-	swiggyPrice = float64(int(swiggyPrice*100)) / 100
-
-	// Delivery times and offers
-	zomatoDeliveryTime := 25 + rand.Intn(20) // 25-45 minutes
-	swiggyDeliveryTime := 20 + rand.Intn(25) // 20-45 minutes
-
-	// Generate appropriate offers
-	zomatoOffer := "20% off"
-	swiggyOffer := "Free delivery"
-
-	// Different offers based on restaurant
-	if strings.Contains(strings.ToLower(restaurant), "p") {
-		zomatoOffer = "Buy 1 Get 1"
-	}
-	if strings.Contains(strings.ToLower(restaurant), "b") {
-		swiggyOffer = "₹50 off"
+// Report whether a list holds a value, ignoring case
+func containsFold(list []string, value string) bool {
+	for _, item := range list {
+		if strings.EqualFold(item, value) {
+			return true
+		}
 	}
+	return false
+}
 
-	return []ServiceOffer{
-		{ServiceName: "Zomato", Price: zomatoPrice, Offer: zomatoOffer, DeliveryTime: zomatoDeliveryTime},
-		{ServiceName: "Swiggy", Price: swiggyPrice, Offer: swiggyOffer, DeliveryTime: swiggyDeliveryTime},
+// Build a compare request for a category from the URL query parameters
+func requestFromQuery(category string, r *http.Request) RealTimeRequest {
+	query := r.URL.Query()
+	request := RealTimeRequest{
+		ID:          query.Get("id"),
+		Category:    category,
+		FromCountry: strings.ToLower(query.Get("fromCountry")),
+		FromState:   strings.ToLower(query.Get("fromState")),
+		ToCountry:   strings.ToLower(query.Get("toCountry")),
+		ToState:     strings.ToLower(query.Get("toState")),
+		FromCity:    strings.ToLower(query.Get("fromCity")),
+		FromAddress: strings.ToLower(query.Get("fromAddress")),
+		ToCity:      strings.ToLower(query.Get("toCity")),
+		ToAddress:   strings.ToLower(query.Get("toAddress")),
+		Country:     strings.ToLower(query.Get("country")),
+		State:       strings.ToLower(query.Get("state")),
+		City:        strings.ToLower(query.Get("city")),
+		Restaurant:  strings.ToLower(query.Get("restaurant")),
+		Address:     strings.ToLower(query.Get("address")),
+		GroceryItem: strings.ToLower(query.Get("groceryItem")),
+	}
+	if raw := query.Get("timeWeight"); raw != "" {
+		weight, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			// Reported by rankingProblem
+			weight = math.NaN()
+		}
+		request.TimeWeight = &weight
 	}
+	return request
 }
 
-// Generate dynamic quick commerce offers for any address in any city
-func generateDynamicQuickCommerceOffers(address, city string) []ServiceOffer {
-	// Base price depends on address and city
-	addressLen := len(address)
-	cityLen := len(city)
-
-	// Base price formula
-	basePrice := float64(addressLen*3 + cityLen*5)
-	if basePrice < 80 {
-		basePrice = 80 // Minimum price
-	}
-	if basePrice > 250 {
-		basePrice = 250 // Maximum price
+// Validate a compare request, quote it and write the offers
+func writeComparison(w http.ResponseWriter, r *http.Request, request RealTimeRequest) {
+	if perr := validateCompare(request); perr != nil {
+		writeError(w, r, perr)
+		return
 	}
-
-	// Adjust for busy locations
-	busyLocations := map[string]bool{
-		"railway station": true, "airport": true, "central mall": true,
-		"main market": true, "metro station": true,
+	if perr := checkCatalog(request); perr != nil {
+		writeError(w, r, perr)
+		return
 	}
 
-	if busyLocations[strings.ToLower(address)] {
-		basePrice *= 1.15 // Higher pricing for busy areas
+	// Every taxi comparison adds to the demand on the route
+	if request.Category == CategoryTaxi {
+		surge.RecordRequest(streamKey(request))
 	}
 
-	// Different pricing for different services
-	zeptoPrice := basePrice * (1.0 + (rnd.Float64() * 0.1))
-	blinkitPrice := basePrice * (0.95 + (rnd.Float64() * 0.1)) // Slightly cheaper on average
-
-	// Round to 2 decimal places
-	zeptoPrice = float64(int(zeptoPrice*100)) / 100
-	blinkitPrice = float64(int(blinkitPrice*100)) / 100
-
-	// Delivery times and offers
-	zeptoDeliveryTime := 10 + rand.Intn(10)  // 10-20 minutes
-	blinkitDeliveryTime := 8 + rand.Intn(12) // 8-20 minutes
-
-	// Generate appropriate offers
-	zeptoOffer := "Free delivery"
-	blinkitOffer := "15% off"
-
-	// Different offers based on location
-	if strings.Contains(strings.ToLower(address), "station") {
-		zeptoOffer = "₹30 cashback"
-	}
-	if strings.Contains(strings.ToLower(address), "central") {
-		blinkitOffer = "Buy 1 Get 1"
+	offers, err := getOrQuoteOffers(r.Context(), request)
+	if err != nil {
+		writeError(w, r, quoteError(err))
+		return
 	}
 
-	return []ServiceOffer{
-		{ServiceName: "Zepto", Price: zeptoPrice, Offer: zeptoOffer, DeliveryTime: zeptoDeliveryTime},
-		{ServiceName: "Blinkit", Price: blinkitPrice, Offer: blinkitOffer, DeliveryTime: blinkitDeliveryTime},
-	}
+	writeJSON(w, r, http.StatusOK, offers)
 }
 
-// Generate dynamic offers for a specific grocery item
-func generateDynamicGroceryItemOffers(groceryItem, address string) []ServiceOffer {
-	// Base price depends on grocery item properties
-	itemLen := len(groceryItem)
-
-	// Generate a base price based on the item name
-	var basePrice float64
-
-	// Price categories based on grocery type
-	// This mimics real-world pricing where some grocery categories are more expensive
-	if strings.Contains(strings.ToLower(groceryItem), "rice") ||
-		strings.Contains(strings.ToLower(groceryItem), "flour") ||
-		strings.Contains(strings.ToLower(groceryItem), "dal") {
-		// Staples
-		basePrice = 80.0 + (float64(itemLen) * 2.5)
-	} else if strings.Contains(strings.ToLower(groceryItem), "oil") ||
-		strings.Contains(strings.ToLower(groceryItem), "ghee") {
-		// Cooking oils
-		basePrice = 120.0 + (float64(itemLen) * 3.5)
-	} else if strings.Contains(strings.ToLower(groceryItem), "milk") ||
-		strings.Contains(strings.ToLower(groceryItem), "bread") ||
-		strings.Contains(strings.ToLower(groceryItem), "egg") {
-		// Daily essentials
-		basePrice = 50.0 + (float64(itemLen) * 1.5)
-	} else if strings.Contains(strings.ToLower(groceryItem), "fruit") ||
-		strings.Contains(strings.ToLower(groceryItem), "vegetable") {
-		// Fresh produce
-		basePrice = 100.0 + (float64(itemLen) * 2.0)
-	} else {
-		// Other grocery items
-		basePrice = 70.0 + (float64(itemLen) * 2.0)
-	}
-
-	// Adjust for premium items
-	if strings.Contains(strings.ToLower(groceryItem), "premium") ||
-		strings.Contains(strings.ToLower(groceryItem), "organic") {
-		basePrice *= 1.3 // Premium pricing
-	}
-
-	// Different pricing for different services
-	zeptoPrice := basePrice * (1.0 + (rnd.Float64() * 0.1))
-	blinkitPrice := basePrice * (0.95 + (rnd.Float64() * 0.1)) // Slightly cheaper on average
-
-	// Round to 2 decimal places
-	zeptoPrice = float64(int(zeptoPrice*100)) / 100
-	blinkitPrice = float64(int(blinkitPrice*100)) / 100
-
-	// Delivery times
-	zeptoDeliveryTime := 10 + rand.Intn(5)  // 10-15 minutes (faster for specific items)
-	blinkitDeliveryTime := 8 + rand.Intn(7) // 8-15 minutes
-
-	// Generate appropriate offers based on item type
-	zeptoOffer := "Free delivery"
-	blinkitOffer := "15% off"
-
-	// Specific offers based on item category
-	if strings.Contains(strings.ToLower(groceryItem), "fresh") {
-		zeptoOffer = "Farm fresh guarantee"
-	} else if strings.Contains(strings.ToLower(groceryItem), "pack") {
-		blinkitOffer = "Buy 2 Get 1 free"
-	} else if strings.Contains(strings.ToLower(groceryItem), "oil") ||
-		strings.Contains(strings.ToLower(groceryItem), "ghee") {
-		zeptoOffer = "₹50 off on next order"
-	} else if strings.Contains(strings.ToLower(groceryItem), "rice") ||
-		strings.Contains(strings.ToLower(groceryItem), "flour") {
-		blinkitOffer = "Free kitchen tool"
-	}
-
-	return []ServiceOffer{
-		{ServiceName: "Zepto", Price: zeptoPrice, Offer: zeptoOffer, DeliveryTime: zeptoDeliveryTime},
-		{ServiceName: "Blinkit", Price: blinkitPrice, Offer: blinkitOffer, DeliveryTime: blinkitDeliveryTime},
+// Compare services of any category. The body is a RealTimeRequest, the
+// same as a WebSocket subscription without alerts.
+func compare(w http.ResponseWriter, r *http.Request) {
+	var request RealTimeRequest
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&request); err != nil {
+		writeError(w, r, &ProtocolError{
+			Code:    ErrCodeInvalidMessage,
+			Message: fmt.Sprintf("request body is not a valid compare request: %v", err),
+		})
+		return
 	}
+	writeComparison(w, r, request)
 }
 
+// Compare taxi services
 func compareTaxi(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
-
-	fromCountry := strings.ToLower(r.URL.Query().Get("fromCountry"))
-	fromState := strings.ToLower(r.URL.Query().Get("fromState"))
-	toCountry := strings.ToLower(r.URL.Query().Get("toCountry"))
-	toState := strings.ToLower(r.URL.Query().Get("toState"))
-
-	// Create a key to look up in the taxi services map
-	key := fmt.Sprintf("%s:%s:%s:%s", fromCountry, fromState, toCountry, toState)
-
-	// Check for existing data or generate dynamic offers
-	var offers []ServiceOffer
-	if existingOffers, exists := taxiServices[key]; exists {
-		offers = existingOffers
-	} else {
-		offers = generateDynamicTaxiOffers(fromState, toState)
-		taxiServices[key] = offers
-	}
-
-	json.NewEncoder(w).Encode(offers)
+	writeComparison(w, r, requestFromQuery(CategoryTaxi, r))
 }
 
 // Compare restaurant delivery services
 func compareRestaurant(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
-
-	country := strings.ToLower(r.URL.Query().Get("country"))
-	state := strings.ToLower(r.URL.Query().Get("state"))
-	city := strings.ToLower(r.URL.Query().Get("city"))
-	restaurant := strings.ToLower(r.URL.Query().Get("restaurant"))
-
-	// Create a key to look up in the restaurant services map
-	key := fmt.Sprintf("%s:%s:%s:%s", country, state, city, restaurant)
-
-	// Check for existing data or generate dynamic offers
-	var offers []ServiceOffer
-	if existingOffers, exists := restaurantServices[key]; exists {
-		offers = existingOffers
-	} else {
-		offers = generateDynamicRestaurantOffers(restaurant, city)
-		restaurantServices[key] = offers
-	}
-
-	json.NewEncoder(w).Encode(offers)
+	writeComparison(w, r, requestFromQuery(CategoryRestaurant, r))
 }
 
 // Compare quick commerce services
 func compareQuickCommerce(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
-
-	country := strings.ToLower(r.URL.Query().Get("country"))
-	state := strings.ToLower(r.URL.Query().Get("state"))
-	city := strings.ToLower(r.URL.Query().Get("city"))
-	address := strings.ToLower(r.URL.Query().Get("address"))
-	groceryItem := strings.ToLower(r.URL.Query().Get("groceryItem"))
-
-	var key string
-
-	// Create a key to look up in the quick commerce services map
-	if groceryItem != "" {
-		// Include the grocery item in the key if specified
-		key = fmt.Sprintf("%s:%s:%s:%s:%s", country, state, city, address, groceryItem)
-	} else {
-		// Otherwise use the standard key
-		key = fmt.Sprintf("%s:%s:%s:%s", country, state, city, address)
-	}
-
-	// Check for existing data or generate dynamic offers
-	var offers []ServiceOffer
-	if existingOffers, exists := quickCommerceServices[key]; exists {
-		offers = existingOffers
-	} else {
-		// Use appropriate generator based on whether a grocery item is specified
-		if groceryItem != "" {
-			offers = generateDynamicGroceryItemOffers(groceryItem, address)
-		} else {
-			offers = generateDynamicQuickCommerceOffers(address, city)
-		}
-		quickCommerceServices[key] = offers
-	}
-
-	json.NewEncoder(w).Encode(offers)
+	writeComparison(w, r, requestFromQuery(CategoryQuickCommerce, r))
 }
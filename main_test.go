@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeProvider quotes a fixed price for one category. Quotes wait while
+// block is set, after signalling on quoting.
+type fakeProvider struct {
+	name     string
+	category string
+
+	mu       sync.Mutex
+	price    float64
+	offer    string
+	err      error
+	quoting  chan struct{}
+	block    chan struct{}
+	quotes   int
+	duration int
+}
+
+func (p *fakeProvider) Name() string         { return p.name }
+func (p *fakeProvider) Categories() []string { return []string{p.category} }
+
+func (p *fakeProvider) Quote(ctx context.Context, request RealTimeRequest) ([]ServiceOffer, error) {
+	p.mu.Lock()
+	block, quoting := p.block, p.quoting
+	p.quotes++
+	price, offer, duration, err := p.price, p.offer, p.duration, p.err
+	p.mu.Unlock()
+
+	if quoting != nil {
+		quoting <- struct{}{}
+	}
+	if block != nil {
+		<-block
+	}
+	if err != nil {
+		return nil, err
+	}
+	return []ServiceOffer{{ServiceName: p.name, Price: price, Offer: offer, Duration: duration, DeliveryTime: duration}}, nil
+}
+
+// Replace the offer store and provider registry with empty ones for the
+// length of a test, registering the given providers. Taxi fares do not surge.
+func useTestProviders(t *testing.T, registered ...Provider) {
+	t.Helper()
+
+	oldStore, oldProviders, oldSurge := offerStore, providers, surge
+	t.Cleanup(func() { offerStore, providers, surge = oldStore, oldProviders, oldSurge })
+
+	offerStore = newTestStore(t, newMemoryStorage())
+	// Fares never surge unless a test sets up its own engine
+	surge = NewSurgeEngine(SurgeConfig{Window: Duration{time.Minute}, Max: 1}, systemClock{}, nil)
+	providers = NewProviderRegistry()
+	for _, p := range registered {
+		if err := providers.Register(p); err != nil {
+			t.Fatalf("Register: %v", err)
+		}
+	}
+}
+
+// A taxi request the fake providers can quote
+func testTaxiRequest() RealTimeRequest {
+	return RealTimeRequest{
+		Category:    CategoryTaxi,
+		FromCountry: "India",
+		FromState:   "Punjab",
+		ToCountry:   "India",
+		ToState:     "Delhi",
+	}
+}
@@ -0,0 +1,375 @@
+package main
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// Menus of every restaurant chain in the restaurant catalog, with list
+// prices and packaging charges per unit
+//
+//go:embed data/menus.json
+var menuData []byte
+
+// Most lines and units per line accepted in one dish order
+const (
+	maxDishLines    = 25
+	maxDishQuantity = 20
+)
+
+// DishVariant is one size or version of a dish
+type DishVariant struct {
+	Name  string  `json:"name"`
+	Price float64 `json:"price"`
+}
+
+// Dish is an item on a restaurant menu
+type Dish struct {
+	Name string `json:"name"`
+	// Packaging charge per unit, whatever the variant
+	Packaging float64       `json:"packaging"`
+	Variants  []DishVariant `json:"variants"`
+}
+
+// Variant returns a variant of the dish by case-insensitive name
+func (d Dish) Variant(name string) (DishVariant, bool) {
+	for _, variant := range d.Variants {
+		if strings.EqualFold(variant.Name, name) {
+			return variant, true
+		}
+	}
+	return DishVariant{}, false
+}
+
+func (d Dish) variantNames() []string {
+	names := make([]string, len(d.Variants))
+	for i, variant := range d.Variants {
+		names[i] = variant.Name
+	}
+	return names
+}
+
+// Menu is the menu of one restaurant chain
+type Menu struct {
+	Restaurant string `json:"restaurant"`
+	Dishes     []Dish `json:"dishes"`
+}
+
+// Dish returns a dish on the menu by case-insensitive name
+func (m Menu) Dish(name string) (Dish, bool) {
+	for _, dish := range m.Dishes {
+		if strings.EqualFold(dish.Name, name) {
+			return dish, true
+		}
+	}
+	return Dish{}, false
+}
+
+// menuFile is the layout of data/menus.json
+type menuFile struct {
+	Restaurants []struct {
+		Name   string `json:"name"`
+		Dishes []Dish `json:"dishes"`
+	} `json:"restaurants"`
+}
+
+// MenuCatalog looks up menus by case-insensitive restaurant name
+type MenuCatalog struct {
+	menus map[string]Menu
+}
+
+// Parse menus in the data/menus.json layout
+func loadMenus(data []byte) (*MenuCatalog, error) {
+	var file menuFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("parsing menus: %w", err)
+	}
+
+	c := &MenuCatalog{menus: make(map[string]Menu)}
+	for _, restaurant := range file.Restaurants {
+		for _, dish := range restaurant.Dishes {
+			if len(dish.Variants) == 0 {
+				return nil, fmt.Errorf("menu of %s: %s has no variants", restaurant.Name, dish.Name)
+			}
+		}
+		c.menus[strings.ToLower(restaurant.Name)] = Menu{Restaurant: restaurant.Name, Dishes: restaurant.Dishes}
+	}
+	return c, nil
+}
+
+// Menu returns the menu of a restaurant chain
+func (c *MenuCatalog) Menu(restaurant string) (Menu, bool) {
+	menu, ok := c.menus[strings.ToLower(restaurant)]
+	return menu, ok
+}
+
+// Check that every restaurant in the restaurant catalog has a menu
+func (c *MenuCatalog) checkCoverage(options map[string]interface{}) error {
+	missing := make(map[string]bool)
+	for _, cities := range options["restaurants"].(map[string]map[string][]string) {
+		for _, restaurants := range cities {
+			for _, restaurant := range restaurants {
+				if _, ok := c.Menu(restaurant); !ok {
+					missing[restaurant] = true
+				}
+			}
+		}
+	}
+
+	if len(missing) > 0 {
+		return fmt.Errorf("no menu for %s", strings.Join(sortedKeys(missing), "; "))
+	}
+	return nil
+}
+
+// menus holds the bundled menus. It is loaded in main.
+var menus *MenuCatalog
+
+// DishItem is one line of a dish order
+type DishItem struct {
+	Dish string `json:"dish"`
+	// May be left out for dishes that come in a single variant
+	Variant  string `json:"variant,omitempty"`
+	Quantity int    `json:"quantity"`
+}
+
+// DishOrderRequest is the body of POST /api/compare/restaurant/dishes
+type DishOrderRequest struct {
+	Country    string     `json:"country"`
+	State      string     `json:"state"`
+	City       string     `json:"city"`
+	Restaurant string     `json:"restaurant"`
+	Items      []DishItem `json:"items"`
+}
+
+// DishLine is a priced line of a dish order
+type DishLine struct {
+	Dish      string  `json:"dish"`
+	Variant   string  `json:"variant"`
+	Quantity  int     `json:"quantity"`
+	UnitPrice float64 `json:"unitPrice"`
+	// Packaging charge of the line before any per-order cap
+	Packaging float64 `json:"packaging"`
+	Total     float64 `json:"total"`
+}
+
+// PlatformOrder is a dish order priced on one platform
+type PlatformOrder struct {
+	Platform  string     `json:"platform"`
+	Lines     []DishLine `json:"lines"`
+	ItemTotal float64    `json:"itemTotal"`
+	// Packaging charge of the whole order, after the platform's cap
+	Packaging float64 `json:"packaging"`
+	// Items, packaging, the platform's delivery and platform fees and GST
+	// on all of them
+	Breakdown    PriceBreakdown `json:"breakdown"`
+	Total        float64        `json:"total"`
+	Offer        string         `json:"offer,omitempty"`
+	DeliveryTime int            `json:"deliveryTime,omitempty"`
+	// Total after the platform's promotions
+	EffectiveTotal float64 `json:"effectiveTotal"`
+}
+
+// DishOrderResponse compares a dish order across platforms, cheapest
+// effective total first
+type DishOrderResponse struct {
+	Restaurant string          `json:"restaurant"`
+	Location   string          `json:"location"`
+	Platforms  []PlatformOrder `json:"platforms"`
+}
+
+// Check a dish order against the restaurant's menu, reporting every problem
+// by field. Names are rewritten to their menu spelling.
+func validateDishOrder(request *DishOrderRequest) *ProtocolError {
+	fields := make(map[string]string)
+
+	for name, value := range map[string]string{
+		"country":    request.Country,
+		"state":      request.State,
+		"city":       request.City,
+		"restaurant": request.Restaurant,
+	} {
+		if strings.TrimSpace(value) == "" {
+			fields[name] = "is required"
+		}
+	}
+
+	// The restaurant itself is checked against the catalog and the menus
+	// after validation; dishes are only checked when its menu is known
+	menu, hasMenu := menus.Menu(request.Restaurant)
+	if hasMenu {
+		request.Restaurant = menu.Restaurant
+	}
+
+	switch {
+	case len(request.Items) == 0:
+		fields["items"] = "must list at least one dish"
+	case len(request.Items) > maxDishLines:
+		fields["items"] = fmt.Sprintf("must list at most %d dishes", maxDishLines)
+	}
+	seen := make(map[string]bool)
+	for i := range request.Items {
+		line := &request.Items[i]
+		name := fmt.Sprintf("items[%d]", i)
+		if line.Quantity < 1 || line.Quantity > maxDishQuantity {
+			fields[name] = fmt.Sprintf("quantity must be between 1 and %d", maxDishQuantity)
+			continue
+		}
+		if !hasMenu {
+			continue
+		}
+
+		dish, ok := menu.Dish(line.Dish)
+		if !ok {
+			fields[name] = fmt.Sprintf("%s has no dish %q", menu.Restaurant, line.Dish)
+			continue
+		}
+		line.Dish = dish.Name
+
+		switch variant, ok := dish.Variant(line.Variant); {
+		case ok:
+			line.Variant = variant.Name
+		case line.Variant == "" && len(dish.Variants) == 1:
+			line.Variant = dish.Variants[0].Name
+		default:
+			fields[name] = fmt.Sprintf("variant must be one of %s", strings.Join(dish.variantNames(), ", "))
+			continue
+		}
+
+		id := line.Dish + "|" + line.Variant
+		if seen[id] {
+			fields[name] = fmt.Sprintf("%s (%s) is listed more than once", line.Dish, line.Variant)
+		}
+		seen[id] = true
+	}
+
+	if len(fields) == 0 {
+		return nil
+	}
+	return fieldErrors("dish order is invalid", fields)
+}
+
+// Compare an order of dishes from one restaurant across the delivery
+// platforms
+func compareDishes(w http.ResponseWriter, r *http.Request) {
+	var request DishOrderRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		writeError(w, r, &ProtocolError{
+			Code:    ErrCodeInvalidMessage,
+			Message: fmt.Sprintf("request body is not a valid dish order: %v", err),
+		})
+		return
+	}
+	if perr := validateDishOrder(&request); perr != nil {
+		writeError(w, r, perr)
+		return
+	}
+	if perr := checkCatalog(RealTimeRequest{
+		Category:   CategoryRestaurant,
+		Country:    request.Country,
+		State:      request.State,
+		City:       request.City,
+		Restaurant: request.Restaurant,
+	}); perr != nil {
+		writeError(w, r, perr)
+		return
+	}
+	menu, ok := menus.Menu(request.Restaurant)
+	if !ok {
+		writeError(w, r, &ProtocolError{
+			Code:    ErrCodeNotFound,
+			Message: "restaurant has no menu",
+			Details: &ErrorDetails{Fields: map[string]string{
+				"restaurant": fmt.Sprintf("no menu for %q", request.Restaurant),
+			}},
+		})
+		return
+	}
+
+	// Each platform's current quote for the restaurant says how far it marks
+	// menu prices up or down, so dish prices follow the same updates as
+	// /api/compare/restaurant
+	offers, err := getOrQuoteOffers(r.Context(), RealTimeRequest{
+		Category:   CategoryRestaurant,
+		Country:    strings.ToLower(request.Country),
+		State:      strings.ToLower(request.State),
+		City:       strings.ToLower(request.City),
+		Restaurant: strings.ToLower(request.Restaurant),
+	})
+	if err != nil {
+		writeError(w, r, quoteError(err))
+		return
+	}
+	if len(offers) == 0 {
+		writeError(w, r, &ProtocolError{
+			Code:    ErrCodeQuoteFailed,
+			Message: "no platform could quote the order",
+		})
+		return
+	}
+
+	base := restaurantBasePrice(request.Restaurant, request.City)
+
+	response := DishOrderResponse{
+		Restaurant: menu.Restaurant,
+		Location:   fmt.Sprintf("%s, %s", request.City, request.State),
+	}
+	for _, offer := range offers {
+		// Compare item costs only, so the platform's fees do not end up in
+		// its dish prices
+		factor := offer.Breakdown.Item / itemizePrice(offer.ServiceName, base).Item
+		order := priceDishes(offer.ServiceName, menu, request.Items, factor)
+		order.Offer = offer.Offer
+		order.DeliveryTime = offer.DeliveryTime
+		order.EffectiveTotal = effectivePrice(order.Total, order.Breakdown, offer.Promotions)
+		response.Platforms = append(response.Platforms, order)
+	}
+	sort.SliceStable(response.Platforms, func(i, j int) bool {
+		return response.Platforms[i].EffectiveTotal < response.Platforms[j].EffectiveTotal
+	})
+
+	writeJSON(w, r, http.StatusOK, response)
+}
+
+// Price dishes on one platform, which sells at factor times the menu price,
+// adding its packaging charge, fees and GST
+func priceDishes(platform string, menu Menu, items []DishItem, factor float64) PlatformOrder {
+	order := PlatformOrder{Platform: platform, Lines: []DishLine{}}
+	packaging := 0.0
+	for _, line := range items {
+		dish, _ := menu.Dish(line.Dish)
+		variant, _ := dish.Variant(line.Variant)
+
+		unitPrice := roundPrice(variant.Price * factor)
+		total := roundTotal(unitPrice * float64(line.Quantity))
+		linePackaging := roundTotal(dish.Packaging * float64(line.Quantity))
+		order.Lines = append(order.Lines, DishLine{
+			Dish:      dish.Name,
+			Variant:   variant.Name,
+			Quantity:  line.Quantity,
+			UnitPrice: unitPrice,
+			Packaging: linePackaging,
+			Total:     total,
+		})
+		order.ItemTotal += total
+		packaging += linePackaging
+	}
+
+	order.ItemTotal = roundTotal(order.ItemTotal)
+	order.Packaging = packagingChargeFor(platform).For(packaging)
+
+	schedule := feeScheduleFor(platform)
+	order.Breakdown = PriceBreakdown{
+		Item:        order.ItemTotal,
+		Delivery:    schedule.Delivery,
+		PlatformFee: schedule.PlatformFee,
+		Packaging:   order.Packaging,
+	}
+	order.Breakdown.GST = roundTotal((order.ItemTotal + schedule.Delivery + schedule.PlatformFee + order.Packaging) * schedule.GSTRate)
+	order.Total = order.Breakdown.Total()
+	return order
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// Load the bundled menus for the length of a test
+func useBundledMenus(t *testing.T) {
+	t.Helper()
+
+	loaded, err := loadMenus(menuData)
+	if err != nil {
+		t.Fatalf("loadMenus: %v", err)
+	}
+	old := menus
+	t.Cleanup(func() { menus = old })
+	menus = loaded
+}
+
+func TestMenusCoverRestaurantCatalog(t *testing.T) {
+	useBundledMenus(t)
+
+	catalog := getDynamicRestaurantOptions(NewRandomSource(1))
+	if err := menus.checkCoverage(map[string]interface{}{"restaurants": catalog}); err != nil {
+		t.Errorf("bundled menus: %v", err)
+	}
+
+	unknown := map[string]map[string][]string{"Punjab": {"Patiala": {"Dominos", "Noma"}}}
+	if err := menus.checkCoverage(map[string]interface{}{"restaurants": unknown}); err == nil || !strings.Contains(err.Error(), "Noma") {
+		t.Errorf("checkCoverage = %v, want Noma reported", err)
+	}
+
+	if _, err := loadMenus([]byte(`{"restaurants":[{"name":"Empty","dishes":[{"name":"Air"}]}]}`)); err == nil {
+		t.Error("loadMenus accepted a dish without variants")
+	}
+}
+
+func testDishOrder() DishOrderRequest {
+	return DishOrderRequest{
+		Country:    "india",
+		State:      "punjab",
+		City:       "patiala",
+		Restaurant: "dominos",
+		Items: []DishItem{
+			{Dish: "margherita", Variant: "medium", Quantity: 2},
+			{Dish: "Garlic Breadsticks", Quantity: 3},
+		},
+	}
+}
+
+func TestValidateDishOrder(t *testing.T) {
+	useBundledMenus(t)
+	useTestCatalog(t, "restaurants", map[string]map[string][]string{"Punjab": {"Patiala": {"Dominos", "Noma"}}})
+
+	request := testDishOrder()
+	if perr := validateDishOrder(&request); perr != nil {
+		t.Fatalf("valid order rejected: %+v", perr)
+	}
+	want := []DishItem{
+		{Dish: "Margherita", Variant: "Medium", Quantity: 2},
+		{Dish: "Garlic Breadsticks", Variant: "Regular", Quantity: 3},
+	}
+	if request.Restaurant != "Dominos" || !reflect.DeepEqual(request.Items, want) {
+		t.Errorf("names not rewritten: %+v", request)
+	}
+
+	request = testDishOrder()
+	request.Items = []DishItem{
+		{Dish: "Margherita", Variant: "Family", Quantity: 1},
+		{Dish: "Farmhouse", Quantity: 1},
+		{Dish: "Sushi", Quantity: 1},
+		{Dish: "Garlic Breadsticks", Quantity: 21},
+		{Dish: "garlic breadsticks", Quantity: 1},
+		{Dish: "Garlic Breadsticks", Variant: "regular", Quantity: 1},
+	}
+	perr := validateDishOrder(&request)
+	if perr == nil {
+		t.Fatal("invalid order accepted")
+	}
+	for field, want := range map[string]string{
+		"items[0]": "variant must be one of Regular, Medium, Large",
+		"items[1]": "variant must be one of",
+		"items[2]": `Dominos has no dish "Sushi"`,
+		"items[3]": "quantity must be between 1 and 20",
+		"items[5]": "Garlic Breadsticks (Regular) is listed more than once",
+	} {
+		if !strings.Contains(perr.Details.Fields[field], want) {
+			t.Errorf("%s = %q, want %q", field, perr.Details.Fields[field], want)
+		}
+	}
+
+	// The restaurant is left to the catalog check; its dishes cannot be
+	// checked without a menu
+	request = testDishOrder()
+	request.Restaurant = "noma"
+	if perr := validateDishOrder(&request); perr != nil {
+		t.Errorf("order from a restaurant without a menu = %+v", perr)
+	}
+	request.Restaurant = ""
+	if perr := validateDishOrder(&request); perr == nil || perr.Details.Fields["restaurant"] != "is required" {
+		t.Errorf("order without a restaurant = %+v", perr)
+	}
+}
+
+func TestPriceDishes(t *testing.T) {
+	useBundledMenus(t)
+	menu, _ := menus.Menu("Dominos")
+	items := []DishItem{
+		{Dish: "Margherita", Variant: "Medium", Quantity: 2},
+		{Dish: "Farmhouse", Variant: "Large", Quantity: 2},
+		{Dish: "Garlic Breadsticks", Variant: "Regular", Quantity: 3},
+	}
+
+	order := priceDishes("Zomato", menu, items, 1.1)
+	wantLines := []DishLine{
+		{Dish: "Margherita", Variant: "Medium", Quantity: 2, UnitPrice: 262.9, Packaging: 30, Total: 525.8},
+		{Dish: "Farmhouse", Variant: "Large", Quantity: 2, UnitPrice: 724.9, Packaging: 40, Total: 1449.8},
+		{Dish: "Garlic Breadsticks", Variant: "Regular", Quantity: 3, UnitPrice: 130.9, Packaging: 30, Total: 392.7},
+	}
+	if !reflect.DeepEqual(order.Lines, wantLines) {
+		t.Errorf("lines = %+v", order.Lines)
+	}
+	// Delivery, the platform fee and GST are added once per order
+	wantBreakdown := PriceBreakdown{Item: 2368.3, Delivery: 30, PlatformFee: 10, GST: 125.42, Packaging: 100}
+	if order.ItemTotal != 2368.3 || order.Packaging != 100 || order.Breakdown != wantBreakdown || order.Total != 2633.72 {
+		t.Errorf("Zomato order = %+v, want 2368.3 plus 100 packaging, 40 in fees and GST", order)
+	}
+
+	// Swiggy caps the packaging charge of an order
+	if order := priceDishes("Swiggy", menu, items, 1.1); order.Packaging != 40 || order.Breakdown.GST != 122.27 || order.Total != 2567.57 {
+		t.Errorf("Swiggy order = %+v, want packaging capped at 40", order)
+	}
+}
+
+func TestCompareDishes(t *testing.T) {
+	useBundledMenus(t)
+	useTestCatalog(t, "restaurants", map[string]map[string][]string{"Punjab": {"Patiala": {"Dominos"}}})
+	base := restaurantBasePrice("dominos", "patiala")
+	useTestProviders(t,
+		&fakeProvider{name: "Zomato", category: CategoryRestaurant, price: base, duration: 30},
+		&fakeProvider{name: "Swiggy", category: CategoryRestaurant, price: base * 0.9, offer: "Free delivery", duration: 25})
+
+	body, _ := json.Marshal(testDishOrder())
+	w := httptest.NewRecorder()
+	compareDishes(w, httptest.NewRequest("POST", "/api/compare/restaurant/dishes", strings.NewReader(string(body))))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", w.Code, w.Body)
+	}
+
+	var response DishOrderResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+	if response.Restaurant != "Dominos" || len(response.Platforms) != 2 {
+		t.Fatalf("response = %+v", response)
+	}
+	swiggy, zomato := response.Platforms[0], response.Platforms[1]
+	if swiggy.Platform != "Swiggy" || zomato.Platform != "Zomato" {
+		t.Fatalf("platforms not cheapest first: %s, %s", swiggy.Platform, zomato.Platform)
+	}
+	// Zomato quotes the base price, so it sells at menu prices
+	if zomato.Lines[0].UnitPrice != 239 || zomato.ItemTotal != 835 || zomato.DeliveryTime != 30 {
+		t.Errorf("Zomato order = %+v, want menu prices", zomato)
+	}
+	if zomato.Total != zomato.Breakdown.Total() || zomato.Breakdown.Delivery != 30 {
+		t.Errorf("Zomato total = %v, breakdown %+v", zomato.Total, zomato.Breakdown)
+	}
+	// Swiggy's quote is 10% lower, but its fees stay the same, so its item
+	// cost and dish prices drop by more
+	factor := itemizePrice("Swiggy", base*0.9).Item / itemizePrice("Swiggy", base).Item
+	if want := roundPrice(239 * factor); swiggy.Lines[0].UnitPrice != want || want >= 215.1 {
+		t.Errorf("Swiggy Margherita = %v, want %v", swiggy.Lines[0].UnitPrice, want)
+	}
+	// Free delivery takes the delivery fee off the whole order
+	if want := roundTotal(swiggy.Total - 25); swiggy.Breakdown.Delivery != 25 || swiggy.EffectiveTotal != want {
+		t.Errorf("Swiggy effective total = %v, want %v", swiggy.EffectiveTotal, want)
+	}
+	if zomato.EffectiveTotal != zomato.Total {
+		t.Errorf("Zomato effective total = %v, want its total %v", zomato.EffectiveTotal, zomato.Total)
+	}
+}
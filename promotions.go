@@ -0,0 +1,158 @@
+package main
+
+import (
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Kinds of promotion
+const (
+	PromotionPercent      = "percent"
+	PromotionFlat         = "flat"
+	PromotionBuyGet       = "bogo"
+	PromotionCashback     = "cashback"
+	PromotionFreeDelivery = "free-delivery"
+	// A free extra with no price value, such as a free drink
+	PromotionGift = "gift"
+	// Text that could not be interpreted
+	PromotionOther = "other"
+)
+
+// Promotion is one typed rule parsed from an offer's text
+type Promotion struct {
+	Kind string `json:"kind"`
+	// Discount or cashback as a percentage of the item cost
+	Percent float64 `json:"percent,omitempty"`
+	// Discount or cashback in rupees
+	Amount float64 `json:"amount,omitempty"`
+	// Buy Buy, get Get free
+	Buy int `json:"buy,omitempty"`
+	Get int `json:"get,omitempty"`
+	// Largest discount the promotion gives
+	Cap float64 `json:"cap,omitempty"`
+	// Item cost needed for the promotion to apply
+	MinOrder float64 `json:"minOrder,omitempty"`
+	// The saving is credited to the next order or ride
+	NextOrder bool `json:"nextOrder,omitempty"`
+	// Only for first orders or rides, so it does not count towards the
+	// effective price
+	NewUsersOnly bool `json:"newUsersOnly,omitempty"`
+}
+
+var (
+	promoBuyGet    = regexp.MustCompile(`buy (\d+) get (\d+)`)
+	promoPercent   = regexp.MustCompile(`(\d+(?:\.\d+)?)\s*% (off|cashback)`)
+	promoAmount    = regexp.MustCompile(`(?:₹|rs\.?\s*)(\d+(?:\.\d+)?) (off|cashback)`)
+	promoCap       = regexp.MustCompile(`up ?to (?:₹|rs\.?\s*)(\d+(?:\.\d+)?)`)
+	promoMinOrder  = regexp.MustCompile(`(?:above|over|min(?:imum)?(?: order)?(?: of)?) (?:₹|rs\.?\s*)(\d+(?:\.\d+)?)`)
+	promoNextOrder = regexp.MustCompile(`next (?:order|ride)`)
+	promoNewUsers  = regexp.MustCompile(`first (?:order|ride)|new users?`)
+)
+
+// Parse offer text such as "20% off up to ₹100", "₹50 off above ₹199",
+// "Buy 1 Get 1" or "10% cashback". Several promotions may be joined with
+// "+".
+func parsePromotions(text string) []Promotion {
+	var promotions []Promotion
+	for _, part := range strings.Split(text, "+") {
+		part = strings.ToLower(strings.TrimSpace(part))
+		if part == "" {
+			continue
+		}
+		promotions = append(promotions, parsePromotion(part))
+	}
+	return promotions
+}
+
+// Parse one lower-case promotion
+func parsePromotion(text string) Promotion {
+	var p Promotion
+
+	switch {
+	case promoBuyGet.MatchString(text):
+		m := promoBuyGet.FindStringSubmatch(text)
+		p.Kind = PromotionBuyGet
+		p.Buy, _ = strconv.Atoi(m[1])
+		p.Get, _ = strconv.Atoi(m[2])
+	case promoPercent.MatchString(text):
+		m := promoPercent.FindStringSubmatch(text)
+		p.Kind = PromotionPercent
+		if m[2] == "cashback" {
+			p.Kind = PromotionCashback
+		}
+		p.Percent, _ = strconv.ParseFloat(m[1], 64)
+	case promoAmount.MatchString(text):
+		m := promoAmount.FindStringSubmatch(text)
+		p.Kind = PromotionFlat
+		if m[2] == "cashback" {
+			p.Kind = PromotionCashback
+		}
+		p.Amount, _ = strconv.ParseFloat(m[1], 64)
+	case strings.Contains(text, "free delivery"):
+		p.Kind = PromotionFreeDelivery
+	case strings.HasPrefix(text, "free "):
+		p.Kind = PromotionGift
+	default:
+		p.Kind = PromotionOther
+	}
+
+	if m := promoCap.FindStringSubmatch(text); m != nil {
+		p.Cap, _ = strconv.ParseFloat(m[1], 64)
+	}
+	if m := promoMinOrder.FindStringSubmatch(text); m != nil {
+		p.MinOrder, _ = strconv.ParseFloat(m[1], 64)
+	}
+	p.NextOrder = promoNextOrder.MatchString(text)
+	p.NewUsersOnly = promoNewUsers.MatchString(text)
+	return p
+}
+
+// Saving on a price with the given breakdown. Cashback counts as a saving;
+// credit for the next order and promotions for new users do not, as they
+// do not lower what this order costs.
+func (p Promotion) Saving(price float64, breakdown PriceBreakdown) float64 {
+	if p.NewUsersOnly || p.NextOrder || breakdown.Item < p.MinOrder {
+		return 0
+	}
+
+	saving := 0.0
+	switch p.Kind {
+	case PromotionPercent, PromotionCashback:
+		saving = breakdown.Item*p.Percent/100 + p.Amount
+	case PromotionFlat:
+		saving = p.Amount
+	case PromotionBuyGet:
+		// The free units come with the order, so each unit effectively costs
+		// Buy/(Buy+Get) of its price
+		if p.Buy+p.Get > 0 {
+			saving = breakdown.Item * float64(p.Get) / float64(p.Buy+p.Get)
+		}
+	case PromotionFreeDelivery:
+		saving = breakdown.Delivery
+	}
+
+	if p.Cap > 0 {
+		saving = math.Min(saving, p.Cap)
+	}
+	return math.Min(saving, price)
+}
+
+// Price after every promotion, never below zero
+func effectivePrice(price float64, breakdown PriceBreakdown, promotions []Promotion) float64 {
+	effective := price
+	for _, p := range promotions {
+		effective -= p.Saving(price, breakdown)
+	}
+	return roundTotal(math.Max(effective, 0))
+}
+
+// Parse an offer's text and compute its effective price
+func applyPromotions(offer *ServiceOffer) {
+	if offer.Breakdown == nil {
+		itemizeOffer(offer)
+	}
+	offer.Promotions = parsePromotions(offer.Offer)
+	offer.EffectivePrice = effectivePrice(offer.Price, *offer.Breakdown, offer.Promotions)
+}
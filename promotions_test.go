@@ -0,0 +1,61 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParsePromotions(t *testing.T) {
+	tests := []struct {
+		text string
+		want []Promotion
+	}{
+		{"10% cashback", []Promotion{{Kind: PromotionCashback, Percent: 10}}},
+		{"₹100 off next ride", []Promotion{{Kind: PromotionFlat, Amount: 100, NextOrder: true}}},
+		{"20% off first ride", []Promotion{{Kind: PromotionPercent, Percent: 20, NewUsersOnly: true}}},
+		{"20% off", []Promotion{{Kind: PromotionPercent, Percent: 20}}},
+		{"Buy 1 Get 1", []Promotion{{Kind: PromotionBuyGet, Buy: 1, Get: 1}}},
+		{"₹50 off", []Promotion{{Kind: PromotionFlat, Amount: 50}}},
+		{"Free delivery", []Promotion{{Kind: PromotionFreeDelivery}}},
+		{"₹30 cashback", []Promotion{{Kind: PromotionCashback, Amount: 30}}},
+		{"20% off up to ₹100 + Free delivery", []Promotion{
+			{Kind: PromotionPercent, Percent: 20, Cap: 100},
+			{Kind: PromotionFreeDelivery},
+		}},
+		{"₹50 off above ₹199", []Promotion{{Kind: PromotionFlat, Amount: 50, MinOrder: 199}}},
+		{"Free drink", []Promotion{{Kind: PromotionGift}}},
+		{"Surprise", []Promotion{{Kind: PromotionOther}}},
+	}
+	for _, tt := range tests {
+		if got := parsePromotions(tt.text); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parsePromotions(%q) = %+v, want %+v", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestEffectivePrice(t *testing.T) {
+	breakdown := PriceBreakdown{Item: 300, Delivery: 30, PlatformFee: 10, Packaging: 20, GST: 18}
+	price := breakdown.Total()
+
+	tests := []struct {
+		text string
+		want float64
+	}{
+		{"20% off", price - 60},
+		{"20% off up to ₹50", price - 50},
+		{"10% cashback", price - 30},
+		{"₹50 off above ₹199", price - 50},
+		{"₹50 off above ₹499", price},
+		{"Free delivery", price - 30},
+		{"Buy 1 Get 1", price - 150},
+		// Neither lowers what this order costs
+		{"₹100 off next ride", price},
+		{"20% off first ride", price},
+	}
+	for _, tt := range tests {
+		got := effectivePrice(price, breakdown, parsePromotions(tt.text))
+		if got != roundTotal(tt.want) {
+			t.Errorf("effectivePrice with %q = %v, want %v", tt.text, got, roundTotal(tt.want))
+		}
+	}
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Version of the /ws message protocol
+const protocolVersion = 1
+
+// WebSocket message types
+const (
+	MessageSubscribe   = "subscribe"
+	MessageUnsubscribe = "unsubscribe"
+	MessagePing        = "ping"
+	MessageSnapshot    = "snapshot"
+	MessageAck         = "ack"
+	MessageError       = "error"
+	MessageUpdate      = "update"
+	MessageAlert       = "alert"
+)
+
+// Error codes, shared by /api error bodies and WebSocket error frames
+const (
+	ErrCodeInvalidMessage      = "invalid_message"
+	ErrCodeUnsupportedVersion  = "unsupported_version"
+	ErrCodeUnknownType         = "unknown_type"
+	ErrCodeValidation          = "validation_failed"
+	ErrCodeSubscriptionLimit   = "subscription_limit"
+	ErrCodeUnknownSubscription = "unknown_subscription"
+	ErrCodeNotFound            = "not_found"
+	ErrCodeMethodNotAllowed    = "method_not_allowed"
+	ErrCodeForbidden           = "forbidden"
+	ErrCodeQuoteFailed         = "quote_failed"
+	ErrCodeInternal            = "internal_error"
+)
+
+// ClientMessage is a frame sent by a client on /ws. The envelope fields sit
+// next to the subscription fields, so a bare RealTimeRequest without a type
+// is still accepted as a subscribe.
+//
+//	{"v":1,"type":"subscribe","ref":"r1","id":"trip","category":"taxi",...}
+//	{"v":1,"type":"unsubscribe","ref":"r2","id":"trip"}
+//	{"v":1,"type":"snapshot","ref":"r3","id":"trip"}
+//	{"v":1,"type":"ping","ref":"r4"}
+type ClientMessage struct {
+	Version int    `json:"v,omitempty"`
+	Type    string `json:"type,omitempty"`
+	// Client-chosen reference echoed in the ack or error for this message
+	Ref string `json:"ref,omitempty"`
+	RealTimeRequest
+}
+
+// ServerMessage is an ack or error frame sent by the server
+type ServerMessage struct {
+	Version int    `json:"v"`
+	Type    string `json:"type"`
+	Ref     string `json:"ref,omitempty"`
+	// Subscription the frame refers to
+	ID string `json:"id,omitempty"`
+	// Type of the acknowledged message
+	Ack       string         `json:"ack,omitempty"`
+	Error     *ProtocolError `json:"error,omitempty"`
+	Timestamp int64          `json:"timestamp"`
+}
+
+// ProtocolError describes why a request or client message failed. It is the
+// error body of the HTTP API and the error of WebSocket error frames.
+type ProtocolError struct {
+	Code    string        `json:"code"`
+	Message string        `json:"message"`
+	Details *ErrorDetails `json:"details,omitempty"`
+	// ID of the HTTP request or WebSocket connection, for matching logs
+	RequestID string `json:"requestId,omitempty"`
+}
+
+// ErrorDetails says which parts of a request were wrong
+type ErrorDetails struct {
+	// Field-level validation errors, keyed by JSON field name
+	Fields map[string]string `json:"fields,omitempty"`
+	// Catalog entries close to unknown values, keyed by JSON field name
+	Suggestions map[string][]string `json:"suggestions,omitempty"`
+}
+
+func (e *ProtocolError) Error() string {
+	return e.Message
+}
+
+// Decode a client frame, defaulting the version and treating untyped frames
+// as subscribe requests
+func decodeClientMessage(data []byte) (ClientMessage, *ProtocolError) {
+	var msg ClientMessage
+	if err := json.Unmarshal(data, &msg); err != nil {
+		return msg, &ProtocolError{
+			Code:    ErrCodeInvalidMessage,
+			Message: fmt.Sprintf("message is not valid JSON: %v", err),
+		}
+	}
+
+	if msg.Version == 0 {
+		msg.Version = protocolVersion
+	}
+	if msg.Version != protocolVersion {
+		return msg, &ProtocolError{
+			Code:    ErrCodeUnsupportedVersion,
+			Message: fmt.Sprintf("protocol version %d is not supported, use %d", msg.Version, protocolVersion),
+		}
+	}
+
+	if msg.Type == "" {
+		msg.Type = MessageSubscribe
+	}
+	switch msg.Type {
+	case MessageSubscribe, MessageUnsubscribe, MessageSnapshot, MessagePing:
+	default:
+		return msg, &ProtocolError{
+			Code:    ErrCodeUnknownType,
+			Message: fmt.Sprintf("unknown message type %q", msg.Type),
+		}
+	}
+	return msg, nil
+}
+
+// Check the fields a subscription and a compare request have in common,
+// returning the problems by JSON field name
+func requestProblems(request RealTimeRequest) map[string]string {
+	fields := make(map[string]string)
+
+	require := func(name, value string) {
+		if strings.TrimSpace(value) == "" {
+			fields[name] = "is required"
+		}
+	}
+
+	switch request.Category {
+	case CategoryTaxi:
+		require("fromCountry", request.FromCountry)
+		require("fromState", request.FromState)
+		require("toCountry", request.ToCountry)
+		require("toState", request.ToState)
+		if request.FromAddress != "" && request.FromCity == "" {
+			fields["fromCity"] = "is required with fromAddress"
+		}
+		if request.ToAddress != "" && request.ToCity == "" {
+			fields["toCity"] = "is required with toAddress"
+		}
+	case CategoryRestaurant:
+		require("country", request.Country)
+		require("state", request.State)
+		require("city", request.City)
+		require("restaurant", request.Restaurant)
+	case CategoryQuickCommerce:
+		require("country", request.Country)
+		require("state", request.State)
+		require("city", request.City)
+		require("address", request.Address)
+	case "":
+		fields["category"] = "is required"
+	default:
+		fields["category"] = fmt.Sprintf("unknown category %q", request.Category)
+	}
+
+	if problem := request.rankingProblem(); problem != "" {
+		fields["timeWeight"] = problem
+	}
+	return fields
+}
+
+// Check a subscription request, reporting every problem by field
+func validateSubscription(request RealTimeRequest) *ProtocolError {
+	fields := requestProblems(request)
+
+	for i, rule := range request.Alerts {
+		if rule.ID == "" {
+			rule.ID = fmt.Sprintf("alert-%d", i+1)
+		}
+		if err := rule.validate(); err != nil {
+			fields[fmt.Sprintf("alerts[%d]", i)] = err.Error()
+		}
+	}
+
+	if len(fields) == 0 {
+		return nil
+	}
+	return fieldErrors("subscription request is invalid", fields)
+}
+
+// Check a one-off compare request, reporting every problem by field
+func validateCompare(request RealTimeRequest) *ProtocolError {
+	fields := requestProblems(request)
+	if len(request.Alerts) > 0 {
+		fields["alerts"] = "are only supported on subscriptions"
+	}
+
+	if len(fields) == 0 {
+		return nil
+	}
+	return fieldErrors("compare request is invalid", fields)
+}
@@ -0,0 +1,53 @@
+package main
+
+import "testing"
+
+func TestValidateSubscriptionTaxiAddresses(t *testing.T) {
+	request := testTaxiRequest()
+	request.ID = "trip"
+	if perr := validateSubscription(request); perr != nil {
+		t.Fatalf("state route rejected: %+v", perr)
+	}
+
+	request.FromAddress, request.ToAddress = "airport", "mall"
+	perr := validateSubscription(request)
+	if perr == nil || perr.Details.Fields["fromCity"] == "" || perr.Details.Fields["toCity"] == "" {
+		t.Fatalf("addresses without cities = %+v, want fromCity and toCity errors", perr)
+	}
+
+	request.FromCity, request.ToCity = "new delhi", "patiala"
+	if perr := validateSubscription(request); perr != nil {
+		t.Errorf("address route rejected: %+v", perr)
+	}
+}
+
+func TestValidateCompareRequiresCategoryFields(t *testing.T) {
+	tests := []struct {
+		request RealTimeRequest
+		fields  []string
+	}{
+		{RealTimeRequest{}, []string{"category"}},
+		{RealTimeRequest{Category: "boats"}, []string{"category"}},
+		{RealTimeRequest{Category: CategoryTaxi}, []string{"fromCountry", "fromState", "toCountry", "toState"}},
+		{RealTimeRequest{Category: CategoryRestaurant, State: "delhi"}, []string{"country", "city", "restaurant"}},
+		{RealTimeRequest{Category: CategoryQuickCommerce, Country: "india"}, []string{"state", "city", "address"}},
+		{RealTimeRequest{Category: CategoryTaxi, FromCountry: "india", FromState: "punjab", ToCountry: "india", ToState: "delhi",
+			Alerts: []AlertRule{{Type: AlertBelow, Threshold: 100}}}, []string{"alerts"}},
+	}
+	for _, tt := range tests {
+		perr := validateCompare(tt.request)
+		if perr == nil || perr.Code != ErrCodeValidation || len(perr.Details.Fields) != len(tt.fields) {
+			t.Errorf("validateCompare(%+v) = %+v, want errors for %v", tt.request, perr, tt.fields)
+			continue
+		}
+		for _, field := range tt.fields {
+			if perr.Details.Fields[field] == "" {
+				t.Errorf("validateCompare(%+v) has no %s error: %v", tt.request, field, perr.Details.Fields)
+			}
+		}
+	}
+
+	if perr := validateCompare(testTaxiRequest()); perr != nil {
+		t.Errorf("complete taxi request rejected: %+v", perr)
+	}
+}
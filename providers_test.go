@@ -0,0 +1,22 @@
+package main
+
+import "testing"
+
+func TestDeliveryFeeFollowsFeeSchedule(t *testing.T) {
+	tests := []struct {
+		provider string
+		subtotal float64
+		want     float64
+	}{
+		{"Zepto", 150, feeSchedules["Zepto"].Delivery},
+		{"Zepto", 199, 0},
+		{"Blinkit", 200, feeSchedules["Blinkit"].Delivery},
+		{"Blinkit", 249, 0},
+		{"Other", 1000, 0},
+	}
+	for _, tt := range tests {
+		if got := deliveryFeeFor(tt.provider).For(tt.subtotal); got != tt.want {
+			t.Errorf("delivery fee of %s for %v = %v, want %v", tt.provider, tt.subtotal, got, tt.want)
+		}
+	}
+}
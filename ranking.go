@@ -0,0 +1,93 @@
+package main
+
+import (
+	"math"
+	"sort"
+)
+
+// Badges given to offers in a ranking
+const (
+	BadgeCheapest  = "cheapest"
+	BadgeFastest   = "fastest"
+	BadgeBestValue = "best value"
+)
+
+// Weight of time against cost when a request does not set timeWeight
+const defaultTimeWeight = 0.3
+
+// Time an offer takes in minutes: the delivery time, or the trip duration
+// for taxis
+func offerMinutes(offer ServiceOffer) int {
+	if offer.DeliveryTime > 0 {
+		return offer.DeliveryTime
+	}
+	return offer.Duration
+}
+
+// Weight of time in the request's ranking, between 0 (cost only) and 1
+// (time only)
+func (r RealTimeRequest) timeWeight() float64 {
+	if r.TimeWeight == nil {
+		return defaultTimeWeight
+	}
+	return *r.TimeWeight
+}
+
+// Describe what is wrong with the request's ranking settings, if anything
+func (r RealTimeRequest) rankingProblem() string {
+	if r.TimeWeight != nil && !(*r.TimeWeight >= 0 && *r.TimeWeight <= 1) {
+		return "must be a number between 0 and 1"
+	}
+	return ""
+}
+
+// Score, rank and badge offers. Effective price and time are each taken
+// relative to the best offer, so a score of 1 is best on both; the score
+// mixes the two by timeWeight. Offers are sorted best first.
+func rankOffers(offers []ServiceOffer, timeWeight float64) {
+	if len(offers) == 0 {
+		return
+	}
+
+	minCost, minTime := math.Inf(1), math.Inf(1)
+	for _, offer := range offers {
+		minCost = math.Min(minCost, offer.EffectivePrice)
+		if minutes := offerMinutes(offer); minutes > 0 {
+			minTime = math.Min(minTime, float64(minutes))
+		}
+	}
+
+	for i := range offers {
+		cost := 1.0
+		if minCost > 0 {
+			cost = offers[i].EffectivePrice / minCost
+		}
+		duration := 1.0
+		if minutes := offerMinutes(offers[i]); minutes > 0 && !math.IsInf(minTime, 1) {
+			duration = float64(minutes) / minTime
+		}
+		offers[i].Score = math.Round(((1-timeWeight)*cost+timeWeight*duration)*1000) / 1000
+	}
+
+	sort.SliceStable(offers, func(i, j int) bool {
+		if offers[i].Score != offers[j].Score {
+			return offers[i].Score < offers[j].Score
+		}
+		return offers[i].EffectivePrice < offers[j].EffectivePrice
+	})
+
+	for i := range offers {
+		offers[i].Rank = i + 1
+		var badges []string
+		if offers[i].EffectivePrice == minCost {
+			badges = append(badges, BadgeCheapest)
+		}
+		if float64(offerMinutes(offers[i])) == minTime {
+			badges = append(badges, BadgeFastest)
+		}
+		if i == 0 {
+			badges = append(badges, BadgeBestValue)
+		}
+		offers[i].Badges = badges
+	}
+}
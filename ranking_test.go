@@ -0,0 +1,91 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestRankOffers(t *testing.T) {
+	offers := func() []ServiceOffer {
+		return []ServiceOffer{
+			{ServiceName: "Zomato", EffectivePrice: 300, DeliveryTime: 40},
+			{ServiceName: "Swiggy", EffectivePrice: 330, DeliveryTime: 20},
+			{ServiceName: "Magicpin", EffectivePrice: 360, DeliveryTime: 30},
+		}
+	}
+
+	tests := []struct {
+		weight float64
+		order  []string
+		scores []float64
+	}{
+		// Cost only: the cheapest offer wins
+		{0, []string{"Zomato", "Swiggy", "Magicpin"}, []float64{1, 1.1, 1.2}},
+		// Time only: the fastest offer wins
+		{1, []string{"Swiggy", "Magicpin", "Zomato"}, []float64{1, 1.5, 2}},
+		// Swiggy's speed makes up for its price, and Magicpin's for its
+		{defaultTimeWeight, []string{"Swiggy", "Magicpin", "Zomato"}, []float64{1.07, 1.29, 1.3}},
+	}
+	for _, tt := range tests {
+		ranked := offers()
+		rankOffers(ranked, tt.weight)
+
+		var order []string
+		var scores []float64
+		for i, offer := range ranked {
+			order = append(order, offer.ServiceName)
+			scores = append(scores, offer.Score)
+			if offer.Rank != i+1 {
+				t.Errorf("weight %v: %s has rank %d, want %d", tt.weight, offer.ServiceName, offer.Rank, i+1)
+			}
+		}
+		if !reflect.DeepEqual(order, tt.order) {
+			t.Errorf("weight %v: order = %v, want %v", tt.weight, order, tt.order)
+		}
+		if !reflect.DeepEqual(scores, tt.scores) {
+			t.Errorf("weight %v: scores = %v, want %v", tt.weight, scores, tt.scores)
+		}
+	}
+}
+
+func TestRankOffersBadges(t *testing.T) {
+	offers := []ServiceOffer{
+		{ServiceName: "Uber", EffectivePrice: 250, Duration: 45},
+		{ServiceName: "Ola", EffectivePrice: 280, Duration: 30},
+		{ServiceName: "Rapido", EffectivePrice: 250, Duration: 50},
+	}
+	rankOffers(offers, 0)
+
+	want := map[string][]string{
+		"Uber":   {BadgeCheapest, BadgeBestValue},
+		"Rapido": {BadgeCheapest},
+		"Ola":    {BadgeFastest},
+	}
+	for _, offer := range offers {
+		if !reflect.DeepEqual(offer.Badges, want[offer.ServiceName]) {
+			t.Errorf("%s badges = %v, want %v", offer.ServiceName, offer.Badges, want[offer.ServiceName])
+		}
+	}
+
+	// A single offer is best on everything
+	single := []ServiceOffer{{ServiceName: "Uber", EffectivePrice: 250, Duration: 45}}
+	rankOffers(single, defaultTimeWeight)
+	if single[0].Score != 1 || single[0].Rank != 1 || len(single[0].Badges) != 3 {
+		t.Errorf("single offer = %+v", single[0])
+	}
+}
+
+func TestRankingProblem(t *testing.T) {
+	weight := func(w float64) *float64 { return &w }
+	for _, tt := range []struct {
+		weight *float64
+		ok     bool
+	}{
+		{nil, true}, {weight(0), true}, {weight(0.6), true}, {weight(1), true}, {weight(-0.1), false}, {weight(1.5), false},
+	} {
+		request := RealTimeRequest{TimeWeight: tt.weight}
+		if got := request.rankingProblem() == ""; got != tt.ok {
+			t.Errorf("rankingProblem(%v) = %q", tt.weight, request.rankingProblem())
+		}
+	}
+}
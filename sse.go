@@ -0,0 +1,482 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Number of recent events kept per stream key for Last-Event-ID resume
+const sseLogSize = 64
+
+// How long the log of a key without streams is kept for reconnecting clients
+const sseLogRetention = 5 * time.Minute
+
+// Interval between keepalive comments on an idle stream
+const sseKeepAlive = 15 * time.Second
+
+// sseEvent is one Server-Sent Event. Events with ID 0 are not logged and
+// are sent without an id field.
+type sseEvent struct {
+	ID   uint64
+	Name string
+	Data []byte
+}
+
+// streamLog holds the recent update events of a stream key and the offers
+// they add up to
+type streamLog struct {
+	events  []sseEvent
+	last    offerSnapshot
+	evicted uint64 // ID of the newest event dropped from the log
+	updated time.Time
+}
+
+// sseStream is one /api/stream connection. Streams for the same request
+// share a key, an event log and a single quote per update.
+type sseStream struct {
+	key     string
+	request RealTimeRequest
+	alerts  *alertState
+	events  chan sseEvent
+	done    chan struct{}
+	closed  bool
+	// ID of the request that opened the stream, for its error events
+	requestID string
+	// An error event was sent and no update has followed yet. Guarded by
+	// h.mu.
+	failing bool
+}
+
+// Identify the update feed of a request
+func streamKey(request RealTimeRequest) string {
+	return request.Category + "|" + offerKey(request)
+}
+
+// Identify the event log of a stream. Streams on the same feed only share
+// events when they rank offers the same way.
+func streamLogKey(request RealTimeRequest) string {
+	key := streamKey(request)
+	if request.TimeWeight != nil {
+		key += "|timeWeight=" + strconv.FormatFloat(*request.TimeWeight, 'g', -1, 64)
+	}
+	return key
+}
+
+// ServeSSE streams real-time updates as text/event-stream. It takes the same
+// fields as RealTimeRequest as query parameters (alerts as a JSON array) and
+// resumes from the Last-Event-ID header or lastEventId parameter.
+func (h *Hub) ServeSSE(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, r, &ProtocolError{
+			Code:    ErrCodeInternal,
+			Message: "streaming is not supported",
+		})
+		return
+	}
+
+	query := r.URL.Query()
+	request := requestFromQuery(query.Get("category"), r)
+	if raw := query.Get("alerts"); raw != "" {
+		if err := json.Unmarshal([]byte(raw), &request.Alerts); err != nil {
+			writeError(w, r, fieldErrors("subscription request is invalid", map[string]string{
+				"alerts": fmt.Sprintf("must be a JSON array of alert rules: %v", err),
+			}))
+			return
+		}
+	}
+	if perr := validateSubscription(request); perr != nil {
+		writeError(w, r, perr)
+		return
+	}
+	if perr := checkCatalog(request); perr != nil {
+		writeError(w, r, perr)
+		return
+	}
+
+	lastEventID := r.Header.Get("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = query.Get("lastEventId")
+	}
+	var resumeFrom uint64
+	if lastEventID != "" {
+		id, err := strconv.ParseUint(lastEventID, 10, 64)
+		if err != nil {
+			writeError(w, r, fieldErrors("subscription request is invalid", map[string]string{
+				"lastEventId": fmt.Sprintf("%q is not an event ID", lastEventID),
+			}))
+			return
+		}
+		resumeFrom = id
+	}
+
+	alerts, _ := newAlertState(request.Alerts)
+	stream := &sseStream{
+		key:     streamLogKey(request),
+		request: request,
+		alerts:  alerts,
+		events:  make(chan sseEvent, h.opts.SendQueueSize),
+		done:    make(chan struct{}),
+
+		requestID: requestIDFrom(r.Context()),
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	// Ask clients to wait a little before reconnecting
+	fmt.Fprintf(w, "retry: %d\n\n", 5000)
+	flusher.Flush()
+
+	h.addStream(r.Context(), stream, resumeFrom)
+	defer h.removeStream(stream)
+
+	log.Printf("New event stream established: %s", r.RemoteAddr)
+
+	keepAlive := time.NewTicker(sseKeepAlive)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case event := <-stream.events:
+			if err := writeSSEEvent(w, event); err != nil {
+				return
+			}
+			flusher.Flush()
+
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(w, ": keepalive\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+
+		case <-stream.done:
+			// Send what was queued before the stream ended, so that the
+			// client resumes after it
+			for {
+				select {
+				case event := <-stream.events:
+					if err := writeSSEEvent(w, event); err != nil {
+						return
+					}
+				default:
+					flusher.Flush()
+					return
+				}
+			}
+
+		case <-r.Context().Done():
+			log.Printf("Event stream closed: %s", r.RemoteAddr)
+			return
+		}
+	}
+}
+
+// Register a stream and queue its first events: the logged events after
+// resumeFrom when none of them were dropped yet, otherwise a full snapshot.
+// The snapshot is quoted without h.mu held, so that a slow provider does not
+// hold up other clients; the stream joins its key once it is quoted.
+func (h *Hub) addStream(ctx context.Context, stream *sseStream, resumeFrom uint64) {
+	h.mu.Lock()
+	// The stream counts as demand from here until removeStream, including
+	// while its snapshot is quoted
+	h.watch(streamKey(stream.request), 1)
+
+	if h.closing {
+		stream.end()
+		h.mu.Unlock()
+		return
+	}
+
+	// Event IDs restart with the process, so IDs from the future cannot resume
+	logged := h.streamLogs[stream.key]
+	if resumeFrom > 0 && logged != nil && resumeFrom >= logged.evicted && resumeFrom <= h.nextEventID {
+		h.register(stream)
+		for _, event := range logged.events {
+			if event.ID > resumeFrom {
+				h.deliver(stream, event)
+			}
+		}
+		h.mu.Unlock()
+		return
+	}
+	h.mu.Unlock()
+
+	response, perr := h.buildRealTimeResponse(ctx, stream.request)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.register(stream)
+	if perr != nil {
+		// Quotes fail when the client goes away, with nobody to tell
+		if ctx.Err() == nil {
+			h.sendError(stream, perr)
+		}
+		return
+	}
+
+	// Later updates are deltas from the key's last update. The first
+	// snapshot of a key becomes that state; later snapshots show it, so
+	// that no change falls between a snapshot and the next delta.
+	logged = h.streamLogs[stream.key]
+	if logged == nil {
+		logged = &streamLog{}
+		h.streamLogs[stream.key] = logged
+	}
+	if logged.last == nil {
+		logged.last = snapshotOffers(response.Offers)
+		logged.updated = h.opts.Clock.Now()
+	} else {
+		for i, offer := range response.Offers {
+			if published, ok := logged.last[offer.ServiceName]; ok {
+				response.Offers[i] = published
+			}
+		}
+	}
+
+	// The snapshot only goes to the new stream, so it is not logged
+	h.nextEventID++
+	response.Seq = h.nextEventID
+	response.Full = true
+	h.send(stream, sseEvent{ID: h.nextEventID, Name: MessageUpdate}, response)
+}
+
+// Add a stream to the streams of its key. A stream that joins while the hub
+// is closing is ended right away. The caller holds h.mu.
+func (h *Hub) register(stream *sseStream) {
+	if h.streams[stream.key] == nil {
+		h.streams[stream.key] = make(map[*sseStream]bool)
+	}
+	h.streams[stream.key][stream] = true
+
+	if h.closing {
+		stream.end()
+	}
+}
+
+func (h *Hub) removeStream(stream *sseStream) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	streams := h.streams[stream.key]
+	delete(streams, stream)
+	h.watch(streamKey(stream.request), -1)
+	if len(streams) == 0 {
+		// Keep the log so that a reconnecting client can still resume
+		delete(h.streams, stream.key)
+	}
+}
+
+// Quote every streamed key once and publish the update to its streams.
+// Quotes run without h.mu held, so that a slow provider does not hold up
+// other clients; the streams of a key are looked up again to publish.
+func (h *Hub) broadcastStreams(ctx context.Context) {
+	h.mu.Lock()
+	// Forget the logs of keys nobody has streamed for a while
+	now := h.opts.Clock.Now()
+	for key, logged := range h.streamLogs {
+		if _, active := h.streams[key]; !active && now.Sub(logged.updated) > sseLogRetention {
+			delete(h.streamLogs, key)
+		}
+	}
+
+	requests := make(map[string]RealTimeRequest, len(h.streams))
+	for key, streams := range h.streams {
+		for stream := range streams {
+			requests[key] = stream.request
+			break
+		}
+	}
+	h.mu.Unlock()
+
+	keys := make([]string, 0, len(requests))
+	for key := range requests {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		response, perr := h.buildRealTimeResponse(ctx, requests[key])
+
+		h.mu.Lock()
+		streams := make([]*sseStream, 0, len(h.streams[key]))
+		for stream := range h.streams[key] {
+			streams = append(streams, stream)
+		}
+		if perr == nil {
+			h.publish(key, streams, response)
+		} else if ctx.Err() == nil {
+			// Quotes fail when the hub shuts down, with nobody to tell
+			for _, stream := range streams {
+				if !stream.failing {
+					h.sendError(stream, perr)
+				}
+			}
+		}
+		h.mu.Unlock()
+	}
+}
+
+// Log the changes of an update for a key and deliver them, followed by any
+// alerts the update fires, to the given streams. Nothing is logged when no
+// provider changed. The caller holds h.mu.
+func (h *Hub) publish(key string, streams []*sseStream, response RealTimeResponse) {
+	logged := h.streamLogs[key]
+	if logged == nil {
+		logged = &streamLog{}
+		h.streamLogs[key] = logged
+	}
+
+	offers := response.Offers
+	if logged.last == nil {
+		response.Full = true
+		logged.last = snapshotOffers(offers)
+	} else {
+		changed, removed, next := diffOffers(logged.last, offers)
+		logged.last = next
+		response.Offers = changed
+		response.Removed = removed
+	}
+
+	event := sseEvent{Name: MessageUpdate}
+	if response.Full || len(response.Offers) > 0 || len(response.Removed) > 0 {
+		h.nextEventID++
+		response.Seq = h.nextEventID
+		event.ID = h.nextEventID
+		event.Data = h.marshalUpdate(response)
+
+		logged.events = append(logged.events, event)
+		if len(logged.events) > sseLogSize {
+			drop := len(logged.events) - sseLogSize
+			logged.evicted = logged.events[drop-1].ID
+			logged.events = append(logged.events[:0:0], logged.events[drop:]...)
+		}
+		logged.updated = h.opts.Clock.Now()
+	}
+
+	// Alerts always look at the complete offer list
+	full := response
+	full.Offers = offers
+	for _, stream := range streams {
+		if stream.failing {
+			// The stream may have missed its snapshot, so it gets the
+			// whole offer list again
+			snapshot := full
+			snapshot.Full = true
+			snapshot.Removed = nil
+			h.nextEventID++
+			snapshot.Seq = h.nextEventID
+			h.send(stream, sseEvent{ID: h.nextEventID, Name: MessageUpdate}, snapshot)
+			stream.failing = false
+			continue
+		}
+		if event.ID > 0 {
+			h.deliver(stream, event)
+		}
+		h.sendAlerts(stream, full)
+	}
+}
+
+// Deliver an update built for a single stream, followed by its alerts
+func (h *Hub) send(stream *sseStream, event sseEvent, response RealTimeResponse) {
+	event.Data = h.marshalUpdate(response)
+	h.deliver(stream, event)
+	h.sendAlerts(stream, response)
+}
+
+func (h *Hub) marshalUpdate(response RealTimeResponse) []byte {
+	// Streams are not part of a client-side subscription set
+	response.ID = ""
+	data, err := json.Marshal(response)
+	if err != nil {
+		log.Printf("Error marshaling stream event: %v", err)
+	}
+	return data
+}
+
+// Deliver an error event to a stream, in the same form as a WebSocket error
+// frame, and mark the stream as failing until its next update. The caller
+// holds h.mu.
+func (h *Hub) sendError(stream *sseStream, perr *ProtocolError) {
+	stream.failing = true
+
+	tagged := *perr
+	tagged.RequestID = stream.requestID
+	data, err := json.Marshal(ServerMessage{
+		Version:   protocolVersion,
+		Type:      MessageError,
+		Error:     &tagged,
+		Timestamp: h.opts.Clock.Now().Unix(),
+	})
+	if err != nil {
+		log.Printf("Error marshaling stream error: %v", err)
+		return
+	}
+	h.deliver(stream, sseEvent{Name: MessageError, Data: data})
+}
+
+// Deliver the alerts an update fires for a stream
+func (h *Hub) sendAlerts(stream *sseStream, response RealTimeResponse) {
+	for _, alert := range stream.alerts.evaluate(response) {
+		data, err := json.Marshal(AlertMessage{
+			Version:   protocolVersion,
+			Type:      MessageAlert,
+			Alert:     alert,
+			Timestamp: response.Timestamp,
+		})
+		if err != nil {
+			log.Printf("Error marshaling stream alert: %v", err)
+			continue
+		}
+		h.deliver(stream, sseEvent{Name: MessageAlert, Data: data})
+	}
+}
+
+// Queue an event for a stream without blocking. A stream whose queue is full
+// is ended under either slow consumer policy: event IDs are shared by all
+// keys, so the client could not tell a dropped event from another stream's,
+// while a reconnect with Last-Event-ID replays what it missed or starts over
+// with a full snapshot. The caller holds h.mu.
+func (h *Hub) deliver(stream *sseStream, event sseEvent) {
+	if stream.closed {
+		return
+	}
+
+	select {
+	case stream.events <- event:
+	default:
+		log.Printf("Ending slow event stream for %s", stream.key)
+		stream.end()
+	}
+}
+
+// End a stream. The caller holds h.mu.
+func (s *sseStream) end() {
+	if !s.closed {
+		s.closed = true
+		close(s.done)
+	}
+}
+
+func writeSSEEvent(w http.ResponseWriter, event sseEvent) error {
+	var b strings.Builder
+	if event.ID > 0 {
+		fmt.Fprintf(&b, "id: %d\n", event.ID)
+	}
+	fmt.Fprintf(&b, "event: %s\n", event.Name)
+	fmt.Fprintf(&b, "data: %s\n\n", event.Data)
+
+	_, err := fmt.Fprint(w, b.String())
+	return err
+}
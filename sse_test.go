@@ -0,0 +1,263 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+)
+
+func newTestStream(request RealTimeRequest, queue int) *sseStream {
+	alerts, _ := newAlertState(request.Alerts)
+	return &sseStream{
+		key:     streamKey(request),
+		request: request,
+		alerts:  alerts,
+		events:  make(chan sseEvent, queue),
+		done:    make(chan struct{}),
+	}
+}
+
+// Take the queued events of a stream
+func queuedEvents(stream *sseStream) []sseEvent {
+	var events []sseEvent
+	for {
+		select {
+		case event := <-stream.events:
+			events = append(events, event)
+		default:
+			return events
+		}
+	}
+}
+
+func setTestPrice(t *testing.T, request RealTimeRequest, name string, price float64) {
+	t.Helper()
+	if err := offerStore.Update(request.Category, offerKey(request), []ServiceOffer{{ServiceName: name, Price: price}}); err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+}
+
+func TestStreamResumesFromLastEventID(t *testing.T) {
+	useTestProviders(t, &fakeProvider{name: "Uber", category: CategoryTaxi, price: 500})
+	hub := NewHub(HubOptions{SendQueueSize: 8})
+	request := testTaxiRequest()
+
+	first := newTestStream(request, 8)
+	hub.addStream(context.Background(), first, 0)
+	for _, price := range []float64{510, 520} {
+		setTestPrice(t, request, "Uber", price)
+		hub.broadcastStreams(context.Background())
+	}
+	events := queuedEvents(first)
+	if len(events) != 3 {
+		t.Fatalf("first stream got %d events, want 3", len(events))
+	}
+
+	// A client that saw the first event gets the two after it from the log
+	resumed := newTestStream(request, 8)
+	hub.addStream(context.Background(), resumed, events[0].ID)
+	replayed := queuedEvents(resumed)
+	if len(replayed) != 2 || replayed[0].ID != events[1].ID || replayed[1].ID != events[2].ID {
+		t.Errorf("resumed stream got %+v, want events %d and %d", replayed, events[1].ID, events[2].ID)
+	}
+
+	var response RealTimeResponse
+	if err := json.Unmarshal(replayed[1].Data, &response); err != nil || response.Offers[0].Price != 520 {
+		t.Errorf("last replayed event = %s", replayed[1].Data)
+	}
+}
+
+func TestSlowStreamIsEnded(t *testing.T) {
+	for _, policy := range []string{SlowConsumerDrop, SlowConsumerDisconnect} {
+		t.Run(policy, func(t *testing.T) {
+			useTestProviders(t, &fakeProvider{name: "Uber", category: CategoryTaxi, price: 500})
+			hub := NewHub(HubOptions{SendQueueSize: 1, SlowConsumerPolicy: policy})
+			request := testTaxiRequest()
+
+			stream := newTestStream(request, 1)
+			hub.addStream(context.Background(), stream, 0)
+			setTestPrice(t, request, "Uber", 510)
+			hub.broadcastStreams(context.Background())
+
+			select {
+			case <-stream.done:
+			default:
+				t.Fatal("stream with a full queue was not ended")
+			}
+			// The queued event is kept for the handler to send
+			if events := queuedEvents(stream); len(events) != 1 {
+				t.Errorf("queued events = %d, want 1", len(events))
+			}
+		})
+	}
+}
+
+// A slow quote for one stream must not hold up the rest of the hub
+func TestStreamQuotesWithoutHubLock(t *testing.T) {
+	provider := &fakeProvider{name: "Uber", category: CategoryTaxi, price: 500,
+		quoting: make(chan struct{}), block: make(chan struct{})}
+	useTestProviders(t, provider)
+	hub := NewHub(HubOptions{SendQueueSize: 8})
+
+	added := make(chan struct{})
+	go func() {
+		defer close(added)
+		hub.addStream(context.Background(), newTestStream(testTaxiRequest(), 8), 0)
+	}()
+	<-provider.quoting
+
+	locked := make(chan struct{})
+	go func() {
+		hub.mu.Lock()
+		hub.mu.Unlock()
+		close(locked)
+	}()
+	select {
+	case <-locked:
+	case <-time.After(time.Second):
+		t.Fatal("hub lock held while quoting a new stream")
+	}
+
+	close(provider.block)
+	<-added
+}
+
+// Only the snapshot of a new stream is full; the next update is a delta of
+// what changed since it
+func TestStreamStartsWithOneFullUpdate(t *testing.T) {
+	useTestProviders(t,
+		&fakeProvider{name: "Uber", category: CategoryTaxi, price: 500},
+		&fakeProvider{name: "Ola", category: CategoryTaxi, price: 450})
+	hub := NewHub(HubOptions{SendQueueSize: 8})
+	request := testTaxiRequest()
+
+	stream := newTestStream(request, 8)
+	hub.addStream(context.Background(), stream, 0)
+	hub.broadcastStreams(context.Background())
+	setTestPrice(t, request, "Uber", 510)
+	hub.broadcastStreams(context.Background())
+
+	events := queuedEvents(stream)
+	if len(events) != 2 {
+		t.Fatalf("got %d events, want a snapshot and one delta", len(events))
+	}
+	var snapshot, delta RealTimeResponse
+	json.Unmarshal(events[0].Data, &snapshot)
+	json.Unmarshal(events[1].Data, &delta)
+	if !snapshot.Full || len(snapshot.Offers) != 2 {
+		t.Errorf("first event = %s, want a full update", events[0].Data)
+	}
+	if delta.Full || len(delta.Offers) != 1 || delta.Offers[0].Price != 510 {
+		t.Errorf("second event = %s, want only the new Uber price", events[1].Data)
+	}
+}
+
+// Deltas build on the last published update, so a stream joining a key shows
+// that state even when prices moved since
+func TestStreamSnapshotMatchesPublishedState(t *testing.T) {
+	useTestProviders(t, &fakeProvider{name: "Uber", category: CategoryTaxi, price: 500})
+	hub := NewHub(HubOptions{SendQueueSize: 8})
+	request := testTaxiRequest()
+
+	hub.addStream(context.Background(), newTestStream(request, 8), 0)
+	setTestPrice(t, request, "Uber", 510)
+
+	joined := newTestStream(request, 8)
+	hub.addStream(context.Background(), joined, 0)
+	hub.broadcastStreams(context.Background())
+
+	events := queuedEvents(joined)
+	if len(events) != 2 {
+		t.Fatalf("got %d events, want a snapshot and one delta", len(events))
+	}
+	var snapshot, delta RealTimeResponse
+	json.Unmarshal(events[0].Data, &snapshot)
+	json.Unmarshal(events[1].Data, &delta)
+	if snapshot.Offers[0].Price != 500 || len(delta.Offers) != 1 || delta.Offers[0].Price != 510 {
+		t.Errorf("events = %s then %s, want 500 then a delta to 510", events[0].Data, events[1].Data)
+	}
+}
+
+func TestCloseEndsStreams(t *testing.T) {
+	useTestProviders(t, &fakeProvider{name: "Uber", category: CategoryTaxi, price: 500})
+	hub := NewHub(HubOptions{SendQueueSize: 8})
+
+	open := newTestStream(testTaxiRequest(), 8)
+	hub.addStream(context.Background(), open, 0)
+	hub.Close("server shutting down")
+	late := newTestStream(testTaxiRequest(), 8)
+	hub.addStream(context.Background(), late, 0)
+
+	for name, stream := range map[string]*sseStream{"open": open, "late": late} {
+		select {
+		case <-stream.done:
+		default:
+			t.Errorf("%s stream was not ended", name)
+		}
+	}
+}
+
+// The log of a key outlives its streams for a while, so that clients can
+// resume, and is then forgotten
+func TestStreamLogsExpire(t *testing.T) {
+	useTestProviders(t, &fakeProvider{name: "Uber", category: CategoryTaxi, price: 500})
+	clock := newFakeClock()
+	hub := NewHub(HubOptions{SendQueueSize: 8, Clock: clock})
+	request := testTaxiRequest()
+
+	stream := newTestStream(request, 8)
+	hub.addStream(context.Background(), stream, 0)
+	setTestPrice(t, request, "Uber", 510)
+	hub.broadcastStreams(context.Background())
+	hub.removeStream(stream)
+
+	clock.Advance(sseLogRetention / 2)
+	hub.broadcastStreams(context.Background())
+	if hub.streamLogs[stream.key] == nil {
+		t.Fatal("log forgotten while still in retention")
+	}
+
+	clock.Advance(sseLogRetention)
+	hub.broadcastStreams(context.Background())
+	if hub.streamLogs[stream.key] != nil {
+		t.Error("log kept after its retention")
+	}
+}
+
+// A stream whose quotes fail is told once, and gets a full update when they
+// succeed again
+func TestStreamQuoteErrorIsSentOnce(t *testing.T) {
+	provider := &fakeProvider{name: "Uber", category: CategoryTaxi, price: 500, err: errors.New("upstream down")}
+	useTestProviders(t, provider)
+	hub := NewHub(HubOptions{SendQueueSize: 8})
+
+	stream := newTestStream(testTaxiRequest(), 8)
+	stream.requestID = "req-1"
+	hub.addStream(context.Background(), stream, 0)
+	hub.broadcastStreams(context.Background())
+	hub.broadcastStreams(context.Background())
+
+	events := queuedEvents(stream)
+	if len(events) != 1 || events[0].Name != MessageError {
+		t.Fatalf("got %+v, want one error event", events)
+	}
+	var message ServerMessage
+	if err := json.Unmarshal(events[0].Data, &message); err != nil || message.Error == nil ||
+		message.Error.Code != ErrCodeQuoteFailed || message.Error.RequestID != "req-1" {
+		t.Errorf("error event = %s, want quote_failed for req-1", events[0].Data)
+	}
+
+	provider.mu.Lock()
+	provider.err = nil
+	provider.mu.Unlock()
+	hub.broadcastStreams(context.Background())
+
+	events = queuedEvents(stream)
+	var response RealTimeResponse
+	if len(events) != 1 || events[0].Name != MessageUpdate || json.Unmarshal(events[0].Data, &response) != nil ||
+		!response.Full || len(response.Offers) != 1 {
+		t.Errorf("after recovery got %+v, want one full update", events)
+	}
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// Storage drivers
+const (
+	StorageMemory = "memory"
+	StorageBolt   = "bolt"
+)
+
+// OfferRecord is the set of offers stored for one category key
+type OfferRecord struct {
+	Category string
+	Key      string
+	Offers   []ServiceOffer
+}
+
+// Storage persists offers per category key and the generated catalogs
+type Storage interface {
+	// LoadOffers returns every stored offer list for a category
+	LoadOffers(category string) (map[string][]ServiceOffer, error)
+	// SaveOffers stores the given records in a single write
+	SaveOffers(records ...OfferRecord) error
+	// LoadCatalog decodes a stored catalog into v and reports whether it existed
+	LoadCatalog(name string, v interface{}) (bool, error)
+	// SaveCatalog stores a catalog under a name
+	SaveCatalog(name string, v interface{}) error
+	// Close releases the underlying resources
+	Close() error
+}
+
+// Open a storage driver by name. path is only used by on-disk drivers.
+func openStorage(driver, path string) (Storage, error) {
+	switch driver {
+	case "", StorageMemory:
+		return newMemoryStorage(), nil
+	case StorageBolt:
+		return newBoltStorage(path)
+	}
+	return nil, fmt.Errorf("unknown storage driver %q", driver)
+}
+
+// memoryStorage keeps everything in process memory and is lost on restart
+type memoryStorage struct {
+	mu       sync.RWMutex
+	offers   map[string]map[string][]ServiceOffer
+	catalogs map[string][]byte
+}
+
+func newMemoryStorage() *memoryStorage {
+	return &memoryStorage{
+		offers:   make(map[string]map[string][]ServiceOffer),
+		catalogs: make(map[string][]byte),
+	}
+}
+
+func (m *memoryStorage) LoadOffers(category string) (map[string][]ServiceOffer, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	result := make(map[string][]ServiceOffer, len(m.offers[category]))
+	for key, offers := range m.offers[category] {
+		result[key] = copyOffers(offers)
+	}
+	return result, nil
+}
+
+func (m *memoryStorage) SaveOffers(records ...OfferRecord) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, record := range records {
+		if m.offers[record.Category] == nil {
+			m.offers[record.Category] = make(map[string][]ServiceOffer)
+		}
+		m.offers[record.Category][record.Key] = copyOffers(record.Offers)
+	}
+	return nil
+}
+
+func (m *memoryStorage) LoadCatalog(name string, v interface{}) (bool, error) {
+	m.mu.RLock()
+	data, ok := m.catalogs[name]
+	m.mu.RUnlock()
+
+	if !ok {
+		return false, nil
+	}
+	return true, json.Unmarshal(data, v)
+}
+
+func (m *memoryStorage) SaveCatalog(name string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	m.catalogs[name] = data
+	m.mu.Unlock()
+	return nil
+}
+
+func (m *memoryStorage) Close() error {
+	return nil
+}
+
+var (
+	boltOffersBucket   = []byte("offers")
+	boltCatalogsBucket = []byte("catalogs")
+)
+
+// boltStorage keeps offers and catalogs in an embedded bbolt database.
+// Offers live in one nested bucket per category, catalogs in a flat bucket,
+// and every value is stored as JSON.
+type boltStorage struct {
+	db *bolt.DB
+}
+
+func newBoltStorage(path string) (*boltStorage, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 2 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("opening %s: %w", path, err)
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		if _, err := tx.CreateBucketIfNotExists(boltOffersBucket); err != nil {
+			return err
+		}
+		_, err := tx.CreateBucketIfNotExists(boltCatalogsBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &boltStorage{db: db}, nil
+}
+
+func (b *boltStorage) LoadOffers(category string) (map[string][]ServiceOffer, error) {
+	result := make(map[string][]ServiceOffer)
+
+	err := b.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(boltOffersBucket).Bucket([]byte(category))
+		if bucket == nil {
+			return nil
+		}
+		return bucket.ForEach(func(k, v []byte) error {
+			var offers []ServiceOffer
+			if err := json.Unmarshal(v, &offers); err != nil {
+				return fmt.Errorf("decoding offers for %s %q: %w", category, k, err)
+			}
+			result[string(k)] = offers
+			return nil
+		})
+	})
+	return result, err
+}
+
+func (b *boltStorage) SaveOffers(records ...OfferRecord) error {
+	if len(records) == 0 {
+		return nil
+	}
+
+	return b.db.Update(func(tx *bolt.Tx) error {
+		root := tx.Bucket(boltOffersBucket)
+		for _, record := range records {
+			bucket, err := root.CreateBucketIfNotExists([]byte(record.Category))
+			if err != nil {
+				return err
+			}
+			data, err := json.Marshal(record.Offers)
+			if err != nil {
+				return err
+			}
+			if err := bucket.Put([]byte(record.Key), data); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (b *boltStorage) LoadCatalog(name string, v interface{}) (bool, error) {
+	var data []byte
+	err := b.db.View(func(tx *bolt.Tx) error {
+		if stored := tx.Bucket(boltCatalogsBucket).Get([]byte(name)); stored != nil {
+			// Values are only valid for the life of the transaction
+			data = append([]byte(nil), stored...)
+		}
+		return nil
+	})
+	if err != nil || data == nil {
+		return false, err
+	}
+	return true, json.Unmarshal(data, v)
+}
+
+func (b *boltStorage) SaveCatalog(name string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	return b.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltCatalogsBucket).Put([]byte(name), data)
+	})
+}
+
+func (b *boltStorage) Close() error {
+	return b.db.Close()
+}
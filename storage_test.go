@@ -0,0 +1,85 @@
+package main
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestStorageDrivers(t *testing.T) {
+	drivers := map[string]func(t *testing.T) Storage{
+		StorageMemory: func(t *testing.T) Storage { return newMemoryStorage() },
+		StorageBolt: func(t *testing.T) Storage {
+			storage, err := newBoltStorage(filepath.Join(t.TempDir(), "test.db"))
+			if err != nil {
+				t.Fatalf("newBoltStorage: %v", err)
+			}
+			return storage
+		},
+	}
+
+	for name, open := range drivers {
+		t.Run(name, func(t *testing.T) {
+			storage := open(t)
+			defer storage.Close()
+
+			taxi := []ServiceOffer{{ServiceName: "Uber", Price: 150.5, Offer: "10% cashback", Duration: 30}}
+			food := []ServiceOffer{{ServiceName: "Zomato", Price: 300, DeliveryTime: 25}}
+			err := storage.SaveOffers(
+				OfferRecord{Category: CategoryTaxi, Key: "a", Offers: taxi},
+				OfferRecord{Category: CategoryRestaurant, Key: "b", Offers: food},
+			)
+			if err != nil {
+				t.Fatalf("SaveOffers: %v", err)
+			}
+
+			stored, err := storage.LoadOffers(CategoryTaxi)
+			if err != nil {
+				t.Fatalf("LoadOffers: %v", err)
+			}
+			if !reflect.DeepEqual(stored, map[string][]ServiceOffer{"a": taxi}) {
+				t.Errorf("LoadOffers(taxi) = %+v", stored)
+			}
+			if stored, _ := storage.LoadOffers(CategoryQuickCommerce); len(stored) != 0 {
+				t.Errorf("LoadOffers of an empty category = %+v", stored)
+			}
+
+			var catalog map[string][]string
+			if ok, err := storage.LoadCatalog("restaurants", &catalog); ok || err != nil {
+				t.Errorf("LoadCatalog before saving = %v, %v", ok, err)
+			}
+			want := map[string][]string{"Delhi": {"Haldiram's", "Theobroma"}}
+			if err := storage.SaveCatalog("restaurants", want); err != nil {
+				t.Fatalf("SaveCatalog: %v", err)
+			}
+			if ok, err := storage.LoadCatalog("restaurants", &catalog); !ok || err != nil || !reflect.DeepEqual(catalog, want) {
+				t.Errorf("LoadCatalog = %v, %v, %+v", ok, err, catalog)
+			}
+		})
+	}
+}
+
+func TestBoltStorageSurvivesReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	storage, err := newBoltStorage(path)
+	if err != nil {
+		t.Fatalf("newBoltStorage: %v", err)
+	}
+	storage.SaveOffers(OfferRecord{Category: CategoryTaxi, Key: "a", Offers: []ServiceOffer{{ServiceName: "Ola", Price: 99}}})
+	storage.Close()
+
+	storage, err = newBoltStorage(path)
+	if err != nil {
+		t.Fatalf("reopening: %v", err)
+	}
+	defer storage.Close()
+	if stored, _ := storage.LoadOffers(CategoryTaxi); len(stored["a"]) != 1 || stored["a"][0].Price != 99 {
+		t.Errorf("offers after reopening = %+v", stored)
+	}
+}
+
+func TestOpenStorageRejectsUnknownDriver(t *testing.T) {
+	if _, err := openStorage("postgres", ""); err == nil {
+		t.Error("openStorage accepted an unknown driver")
+	}
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+)
+
+// OfferStore holds the offers for every category, keyed by the lookup key
+// built from a request. It is safe for concurrent use by the REST handlers,
+// the WebSocket path and the price update routine.
+//
+// The store keeps its working set in memory and writes every change through
+// to a Storage driver, so offers survive a restart with on-disk drivers.
+// Writes happen after mu is released, so readers never wait for the disk.
+type OfferStore struct {
+	mu       sync.RWMutex
+	offers   map[string]map[string][]ServiceOffer // category -> key -> offers
+	storage  Storage
+	watchers []func(records []OfferRecord)
+	// Held by a change from before it takes mu until its write is done, so
+	// changes reach storage in the order they were made
+	saveMu sync.Mutex
+}
+
+// NewOfferStore creates a store for the known categories. Offers already
+// persisted in storage are loaded first; seed offers are only added (and
+// persisted) for keys the storage does not know yet.
+func NewOfferStore(storage Storage, seed map[string]map[string][]ServiceOffer) (*OfferStore, error) {
+	s := &OfferStore{
+		offers: map[string]map[string][]ServiceOffer{
+			CategoryTaxi:          {},
+			CategoryRestaurant:    {},
+			CategoryQuickCommerce: {},
+		},
+		storage: storage,
+	}
+
+	var missing []OfferRecord
+	for category := range s.offers {
+		stored, err := storage.LoadOffers(category)
+		if err != nil {
+			return nil, fmt.Errorf("loading %s offers: %w", category, err)
+		}
+		for key, offers := range stored {
+			s.offers[category][key] = offers
+		}
+
+		for key, offers := range seed[category] {
+			if _, ok := s.offers[category][key]; ok {
+				continue
+			}
+			s.offers[category][key] = copyOffers(offers)
+			missing = append(missing, OfferRecord{Category: category, Key: key, Offers: offers})
+		}
+	}
+
+	if err := storage.SaveOffers(missing...); err != nil {
+		return nil, fmt.Errorf("saving seed offers: %w", err)
+	}
+	return s, nil
+}
+
+// Watch registers fn to be called with the records written by every change.
+// fn runs after the store lock is released and must not modify the records.
+func (s *OfferStore) Watch(fn func(records []OfferRecord)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.watchers = append(s.watchers, fn)
+}
+
+// Get returns a copy of the offers stored for a key
+func (s *OfferStore) Get(category, key string) ([]ServiceOffer, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	offers, ok := s.offers[category][key]
+	if !ok {
+		return nil, false
+	}
+	return copyOffers(offers), true
+}
+
+// GetOrGenerate returns the offers stored for a key, calling generate and
+// storing its result when the key is not present yet. generate runs without
+// the lock held; if another caller stored the key in the meantime, that
+// result wins.
+func (s *OfferStore) GetOrGenerate(category, key string, generate func() ([]ServiceOffer, error)) ([]ServiceOffer, error) {
+	if err := s.checkCategory(category); err != nil {
+		return nil, err
+	}
+
+	if offers, ok := s.Get(category, key); ok {
+		return offers, nil
+	}
+
+	generated, err := generate()
+	if err != nil {
+		return nil, err
+	}
+
+	s.saveMu.Lock()
+	defer s.saveMu.Unlock()
+
+	s.mu.Lock()
+	if existing, ok := s.offers[category][key]; ok {
+		s.mu.Unlock()
+		return copyOffers(existing), nil
+	}
+	record := OfferRecord{Category: category, Key: key, Offers: copyOffers(generated)}
+	s.offers[category][key] = copyOffers(record.Offers)
+	watchers := s.watchers
+	s.mu.Unlock()
+
+	s.persist(record)
+	notify(watchers, []OfferRecord{record})
+	return generated, nil
+}
+
+// Update replaces the offers stored for a key
+func (s *OfferStore) Update(category, key string, offers []ServiceOffer) error {
+	if err := s.checkCategory(category); err != nil {
+		return err
+	}
+
+	s.saveMu.Lock()
+	defer s.saveMu.Unlock()
+
+	s.mu.Lock()
+	record := OfferRecord{Category: category, Key: key, Offers: copyOffers(offers)}
+	s.offers[category][key] = copyOffers(record.Offers)
+	watchers := s.watchers
+	s.mu.Unlock()
+
+	err := s.storage.SaveOffers(record)
+	notify(watchers, []OfferRecord{record})
+	return err
+}
+
+// UpdateAll calls fn for every stored key while holding the write lock and
+// stores the offers it returns. fn may modify the slice it is given in place.
+// The changes are written to storage once the lock is released.
+func (s *OfferStore) UpdateAll(fn func(category, key string, offers []ServiceOffer) []ServiceOffer) {
+	s.saveMu.Lock()
+	defer s.saveMu.Unlock()
+
+	s.mu.Lock()
+	var records []OfferRecord
+	// Visit keys in a fixed order so that a seeded fn gives the same results
+	// run after run
+	for _, category := range sortedKeys(s.offers) {
+		entries := s.offers[category]
+		for _, key := range sortedKeys(entries) {
+			entries[key] = fn(category, key, entries[key])
+			records = append(records, OfferRecord{Category: category, Key: key, Offers: copyOffers(entries[key])})
+		}
+	}
+	watchers := s.watchers
+	s.mu.Unlock()
+
+	s.persist(records...)
+	notify(watchers, records)
+}
+
+// Write records through to storage. Failures are logged rather than returned
+// so that a storage problem never fails a quote; the in-memory copy stays
+// authoritative until the next successful write.
+func (s *OfferStore) persist(records ...OfferRecord) {
+	if err := s.storage.SaveOffers(records...); err != nil {
+		log.Printf("Error persisting offers: %v", err)
+	}
+}
+
+func (s *OfferStore) checkCategory(category string) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if _, ok := s.offers[category]; !ok {
+		return fmt.Errorf("unknown category %q", category)
+	}
+	return nil
+}
+
+func notify(watchers []func(records []OfferRecord), records []OfferRecord) {
+	for _, watch := range watchers {
+		watch(records)
+	}
+}
+
+func copyOffers(offers []ServiceOffer) []ServiceOffer {
+	if offers == nil {
+		return nil
+	}
+	result := make([]ServiceOffer, len(offers))
+	copy(result, offers)
+	return result
+}
+
+// Keys of a map in sorted order
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newTestStore(t *testing.T, storage Storage) *OfferStore {
+	t.Helper()
+	store, err := NewOfferStore(storage, nil)
+	if err != nil {
+		t.Fatalf("NewOfferStore: %v", err)
+	}
+	return store
+}
+
+func TestOfferStoreGetOrGenerate(t *testing.T) {
+	store := newTestStore(t, newMemoryStorage())
+
+	calls := 0
+	generate := func() ([]ServiceOffer, error) {
+		calls++
+		return []ServiceOffer{{ServiceName: "Zomato", Price: 300}}, nil
+	}
+	for i := 0; i < 3; i++ {
+		offers, err := store.GetOrGenerate(CategoryRestaurant, "key", generate)
+		if err != nil {
+			t.Fatalf("GetOrGenerate: %v", err)
+		}
+		if len(offers) != 1 || offers[0].Price != 300 {
+			t.Fatalf("offers = %+v", offers)
+		}
+	}
+	if calls != 1 {
+		t.Errorf("generate called %d times, want 1", calls)
+	}
+
+	if _, err := store.GetOrGenerate("boats", "key", generate); err == nil {
+		t.Error("GetOrGenerate accepted an unknown category")
+	}
+}
+
+func TestOfferStoreReturnsCopies(t *testing.T) {
+	store := newTestStore(t, newMemoryStorage())
+	if err := store.Update(CategoryTaxi, "key", []ServiceOffer{{ServiceName: "Uber", Price: 100}}); err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+
+	offers, _ := store.Get(CategoryTaxi, "key")
+	offers[0].Price = 1
+	if again, _ := store.Get(CategoryTaxi, "key"); again[0].Price != 100 {
+		t.Errorf("changing a returned offer changed the store: %+v", again)
+	}
+}
+
+func TestOfferStoreUpdateAll(t *testing.T) {
+	store := newTestStore(t, newMemoryStorage())
+	for i := 0; i < 3; i++ {
+		store.Update(CategoryQuickCommerce, fmt.Sprint(i), []ServiceOffer{{ServiceName: "Zepto", Price: 10}})
+	}
+
+	visited := 0
+	store.UpdateAll(func(category, key string, offers []ServiceOffer) []ServiceOffer {
+		visited++
+		offers[0].Price *= 2
+		return offers
+	})
+	if visited != 3 {
+		t.Errorf("UpdateAll visited %d keys, want 3", visited)
+	}
+	for i := 0; i < 3; i++ {
+		if offers, _ := store.Get(CategoryQuickCommerce, fmt.Sprint(i)); offers[0].Price != 20 {
+			t.Errorf("key %d: price %v, want 20", i, offers[0].Price)
+		}
+	}
+}
+
+// Run with -race: handlers and the update routine use the store at once
+func TestOfferStoreConcurrentUse(t *testing.T) {
+	store := newTestStore(t, newMemoryStorage())
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				store.GetOrGenerate(CategoryTaxi, fmt.Sprint(j%10), func() ([]ServiceOffer, error) {
+					return []ServiceOffer{{ServiceName: "Ola", Price: float64(i)}}, nil
+				})
+			}
+		}(i)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				store.UpdateAll(func(category, key string, offers []ServiceOffer) []ServiceOffer {
+					for k := range offers {
+						offers[k].Price++
+					}
+					return offers
+				})
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestOfferStoreWritesThrough(t *testing.T) {
+	storage := newMemoryStorage()
+	store := newTestStore(t, storage)
+	store.GetOrGenerate(CategoryTaxi, "a", func() ([]ServiceOffer, error) {
+		return []ServiceOffer{{ServiceName: "Uber", Price: 100}}, nil
+	})
+	store.Update(CategoryTaxi, "b", []ServiceOffer{{ServiceName: "Ola", Price: 90}})
+	store.UpdateAll(func(category, key string, offers []ServiceOffer) []ServiceOffer {
+		offers[0].Price += 1
+		return offers
+	})
+
+	// A new store over the same storage sees the latest prices
+	reloaded := newTestStore(t, storage)
+	for key, want := range map[string]float64{"a": 101, "b": 91} {
+		if offers, ok := reloaded.Get(CategoryTaxi, key); !ok || offers[0].Price != want {
+			t.Errorf("reloaded %s = %+v, want price %v", key, offers, want)
+		}
+	}
+}
+
+func TestOfferStoreSeedsOnlyMissingKeys(t *testing.T) {
+	storage := newMemoryStorage()
+	storage.SaveOffers(OfferRecord{Category: CategoryTaxi, Key: "a", Offers: []ServiceOffer{{ServiceName: "Uber", Price: 120}}})
+
+	store, err := NewOfferStore(storage, map[string]map[string][]ServiceOffer{
+		CategoryTaxi: {
+			"a": {{ServiceName: "Uber", Price: 100}},
+			"b": {{ServiceName: "Ola", Price: 90}},
+		},
+	})
+	if err != nil {
+		t.Fatalf("NewOfferStore: %v", err)
+	}
+	if offers, _ := store.Get(CategoryTaxi, "a"); offers[0].Price != 120 {
+		t.Errorf("seed replaced a stored offer: %+v", offers)
+	}
+	if stored, _ := storage.LoadOffers(CategoryTaxi); len(stored["b"]) != 1 {
+		t.Errorf("missing seed offer was not persisted: %+v", stored)
+	}
+}
+
+// blockingStorage holds every SaveOffers call until release is closed
+type blockingStorage struct {
+	*memoryStorage
+	saving  chan struct{}
+	release chan struct{}
+}
+
+func (b *blockingStorage) SaveOffers(records ...OfferRecord) error {
+	b.saving <- struct{}{}
+	<-b.release
+	return b.memoryStorage.SaveOffers(records...)
+}
+
+func TestOfferStoreReadsDuringSlowWrites(t *testing.T) {
+	storage := &blockingStorage{memoryStorage: newMemoryStorage(), saving: make(chan struct{}, 1), release: make(chan struct{})}
+	store := newTestStore(t, storage.memoryStorage)
+	store.storage = storage
+	store.offers[CategoryTaxi]["a"] = []ServiceOffer{{ServiceName: "Uber", Price: 100}}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		store.UpdateAll(func(category, key string, offers []ServiceOffer) []ServiceOffer {
+			offers[0].Price = 110
+			return offers
+		})
+	}()
+	<-storage.saving
+
+	read := make(chan []ServiceOffer)
+	go func() {
+		offers, _ := store.Get(CategoryTaxi, "a")
+		read <- offers
+	}()
+	select {
+	case offers := <-read:
+		if offers[0].Price != 110 {
+			t.Errorf("Get during the write = %+v, want the new price", offers)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Get waited for the storage write")
+	}
+
+	close(storage.release)
+	<-done
+}
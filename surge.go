@@ -0,0 +1,190 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+// PeakHours raises fares by Multiplier between Start and End, given as
+// "HH:MM" in local time. A window whose end is before its start runs past
+// midnight.
+type PeakHours struct {
+	Start      string  `json:"start" yaml:"start"`
+	End        string  `json:"end" yaml:"end"`
+	Multiplier float64 `json:"multiplier" yaml:"multiplier"`
+}
+
+// Minutes after midnight of the start and end of the window
+func (p PeakHours) minutes() (int, int, error) {
+	start, err := parseClockTime(p.Start)
+	if err != nil {
+		return 0, 0, fmt.Errorf("start: %w", err)
+	}
+	end, err := parseClockTime(p.End)
+	if err != nil {
+		return 0, 0, fmt.Errorf("end: %w", err)
+	}
+	return start, end, nil
+}
+
+// Report whether the window covers a minute of the day
+func (p PeakHours) covers(minute int) bool {
+	start, end, err := p.minutes()
+	if err != nil {
+		return false
+	}
+	if start <= end {
+		return minute >= start && minute < end
+	}
+	return minute >= start || minute < end
+}
+
+func parseClockTime(value string) (int, error) {
+	t, err := time.Parse("15:04", value)
+	if err != nil {
+		return 0, fmt.Errorf("%q is not HH:MM", value)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// SurgeConfig holds the settings of the taxi surge engine
+type SurgeConfig struct {
+	// Period over which /api/compare/taxi requests are counted
+	Window Duration `json:"window" yaml:"window"`
+	// Requests per window, and live subscribers, that add one step
+	RequestsPerStep    int `json:"requestsPerStep" yaml:"requestsPerStep"`
+	SubscribersPerStep int `json:"subscribersPerStep" yaml:"subscribersPerStep"`
+	// Increase of the multiplier per step
+	Step float64 `json:"step" yaml:"step"`
+	// Highest multiplier ever applied
+	Max float64 `json:"max" yaml:"max"`
+	// Schedule of peak hours
+	PeakHours []PeakHours `json:"peakHours" yaml:"peakHours"`
+	// How strongly each provider follows demand. 1 passes the route
+	// multiplier on as is, 0 never surges. Providers not listed use 1.
+	ProviderFactors map[string]float64 `json:"providerFactors" yaml:"providerFactors"`
+}
+
+// SurgeEngine computes demand-based taxi fare multipliers per route
+type SurgeEngine struct {
+	cfg   SurgeConfig
+	clock Clock
+	// Number of live WebSocket and SSE subscribers on a route
+	subscribers func(route string) int
+
+	mu       sync.Mutex
+	requests map[string][]time.Time // route -> recent compare requests
+}
+
+// NewSurgeEngine creates an engine. subscribers reports the live
+// subscribers of a route key.
+func NewSurgeEngine(cfg SurgeConfig, clock Clock, subscribers func(route string) int) *SurgeEngine {
+	return &SurgeEngine{
+		cfg:         cfg,
+		clock:       clock,
+		subscribers: subscribers,
+		requests:    make(map[string][]time.Time),
+	}
+}
+
+// RecordRequest counts a compare request for a route
+func (e *SurgeEngine) RecordRequest(route string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	now := e.clock.Now()
+	e.requests[route] = append(e.recent(route, now), now)
+}
+
+// Requests in the window before now, pruning older ones. The caller holds
+// e.mu.
+func (e *SurgeEngine) recent(route string, now time.Time) []time.Time {
+	times := e.requests[route]
+	cutoff := now.Add(-e.cfg.Window.Duration)
+
+	i := 0
+	for i < len(times) && !times[i].After(cutoff) {
+		i++
+	}
+	if i == len(times) {
+		delete(e.requests, route)
+		return nil
+	}
+	return times[i:]
+}
+
+// Multiplier returns the route multiplier before provider factors
+func (e *SurgeEngine) Multiplier(route string) float64 {
+	now := e.clock.Now()
+
+	e.mu.Lock()
+	requests := len(e.recent(route, now))
+	e.mu.Unlock()
+
+	steps := 0.0
+	if e.cfg.RequestsPerStep > 0 {
+		steps += float64(requests / e.cfg.RequestsPerStep)
+	}
+	if e.cfg.SubscribersPerStep > 0 && e.subscribers != nil {
+		steps += float64(e.subscribers(route) / e.cfg.SubscribersPerStep)
+	}
+	multiplier := 1 + steps*e.cfg.Step
+
+	minute := now.Hour()*60 + now.Minute()
+	peak := 1.0
+	for _, window := range e.cfg.PeakHours {
+		if window.covers(minute) {
+			peak = math.Max(peak, window.Multiplier)
+		}
+	}
+	multiplier *= peak
+
+	if e.cfg.Max >= 1 {
+		multiplier = math.Min(multiplier, e.cfg.Max)
+	}
+	return multiplier
+}
+
+// Apply sets the surge of each offer and raises its price accordingly
+func (e *SurgeEngine) Apply(route string, offers []ServiceOffer) {
+	multiplier := e.Multiplier(route)
+
+	for i := range offers {
+		factor, ok := e.cfg.ProviderFactors[offers[i].ServiceName]
+		if !ok {
+			factor = 1
+		}
+		applySurge(&offers[i], math.Round((1+(multiplier-1)*factor)*100)/100)
+	}
+}
+
+// Check the surge settings, reporting each problem through add
+func (c SurgeConfig) validate(add func(format string, args ...interface{})) {
+	if c.Window.Duration <= 0 {
+		add("surge window must be positive")
+	}
+	if c.RequestsPerStep < 0 || c.SubscribersPerStep < 0 {
+		add("surge requestsPerStep and subscribersPerStep must not be negative")
+	}
+	if c.Step < 0 {
+		add("surge step must not be negative")
+	}
+	if c.Max < 1 {
+		add("surge max must be at least 1")
+	}
+	for i, window := range c.PeakHours {
+		if _, _, err := window.minutes(); err != nil {
+			add("surge peakHours[%d] %v", i, err)
+		}
+		if window.Multiplier < 1 {
+			add("surge peakHours[%d] multiplier must be at least 1", i)
+		}
+	}
+	for _, provider := range sortedKeys(c.ProviderFactors) {
+		if factor := c.ProviderFactors[provider]; factor < 0 {
+			add("surge providerFactors.%s must not be negative", provider)
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+)
+
+func clockAt(hour, minute int) *fakeClock {
+	return &fakeClock{now: time.Date(2024, 1, 15, hour, minute, 0, 0, time.Local)}
+}
+
+func closeTo(a, b float64) bool {
+	return math.Abs(a-b) < 1e-9
+}
+
+func TestSurgeMultiplier(t *testing.T) {
+	cfg := defaultConfig().Surge
+
+	tests := []struct {
+		name        string
+		clock       Clock
+		requests    int
+		subscribers int
+		want        float64
+	}{
+		{"quiet", clockAt(12, 0), 0, 0, 1},
+		{"morning peak", clockAt(9, 0), 0, 0, 1.2},
+		{"evening peak ends", clockAt(20, 30), 0, 0, 1},
+		{"one step", clockAt(12, 0), 5, 0, 1.1},
+		{"subscribers", clockAt(12, 0), 0, 4, 1.2},
+		{"steps and peak", clockAt(18, 0), 10, 0, 1.2 * 1.3},
+		{"capped", clockAt(18, 0), 200, 0, 2.5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			engine := NewSurgeEngine(cfg, tt.clock, func(string) int { return tt.subscribers })
+			for i := 0; i < tt.requests; i++ {
+				engine.RecordRequest("route")
+			}
+			if got := engine.Multiplier("route"); !closeTo(got, tt.want) {
+				t.Errorf("Multiplier = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSurgeRequestsLeaveTheWindow(t *testing.T) {
+	clock := clockAt(12, 0)
+	engine := NewSurgeEngine(defaultConfig().Surge, clock, nil)
+
+	for i := 0; i < 5; i++ {
+		engine.RecordRequest("route")
+	}
+	clock.Advance(30 * time.Second)
+	if got := engine.Multiplier("route"); !closeTo(got, 1.1) {
+		t.Fatalf("Multiplier within the window = %v, want 1.1", got)
+	}
+
+	clock.Advance(time.Minute)
+	if got := engine.Multiplier("route"); got != 1 {
+		t.Errorf("Multiplier after the window = %v, want 1", got)
+	}
+	if got := engine.Multiplier("other"); got != 1 {
+		t.Errorf("Multiplier of another route = %v, want 1", got)
+	}
+}
+
+func TestSurgeApplyUsesProviderFactors(t *testing.T) {
+	engine := NewSurgeEngine(defaultConfig().Surge, clockAt(9, 0), nil)
+
+	offers := []ServiceOffer{{ServiceName: "Uber", Price: 300}, {ServiceName: "Ola", Price: 300}, {ServiceName: "Rapido", Price: 300}}
+	engine.Apply("route", offers)
+
+	want := map[string]float64{"Uber": 1.2, "Ola": 1.16, "Rapido": 1.2}
+	for _, offer := range offers {
+		if !closeTo(offer.Surge, want[offer.ServiceName]) || !closeTo(offer.Price, roundTotal(300*want[offer.ServiceName])) {
+			t.Errorf("%s: surge %v, price %v, want surge %v", offer.ServiceName, offer.Surge, offer.Price, want[offer.ServiceName])
+		}
+	}
+}
+
+// Event streams count as demand on their route for as long as they are open
+func TestStreamsCountAsSubscribers(t *testing.T) {
+	useTestProviders(t, &fakeProvider{name: "Uber", category: CategoryTaxi, price: 500})
+	hub := NewHub(HubOptions{SendQueueSize: 8})
+	stream := newTestStream(testTaxiRequest(), 8)
+
+	hub.addStream(context.Background(), stream, 0)
+	if got := hub.Subscribers(stream.key); got != 1 {
+		t.Errorf("Subscribers with an open stream = %d, want 1", got)
+	}
+	hub.removeStream(stream)
+	if got := hub.Subscribers(stream.key); got != 0 {
+		t.Errorf("Subscribers after the stream closed = %d, want 0", got)
+	}
+}